@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/achere/g-force/pkg/sfapi"
+)
+
+var (
+	scriptVarRegexp = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+	// anonymousFrameRegexp matches the frames of the script itself in an
+	// exception stack trace, the others point into classes and triggers.
+	anonymousFrameRegexp = regexp.MustCompile(`AnonymousBlock: line (\d+), column (\d+)`)
+)
+
+// scriptVars collects the repeatable -var flag.
+type scriptVars map[string]string
+
+func (v scriptVars) String() string {
+	return ""
+}
+
+func (v scriptVars) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return errors.New("expected name=value")
+	}
+	v[name] = value
+	return nil
+}
+
+// execScripts implements `apexcov exec`: it runs the anonymous Apex scripts
+// passed as arguments in order, stopping on the first one that fails.
+func execScripts(args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	var opts options
+	registerConnectionFlags(fs, &opts)
+	vars := make(scriptVars)
+	fs.Var(
+		vars,
+		"var",
+		"Value of a {{name}} placeholder in the scripts as name=value, can be repeated; environment variables are used for the rest",
+	)
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "missing paths to the Apex scripts to execute")
+		return 1
+	}
+
+	lookup := func(name string) (string, bool) {
+		if v, ok := vars[name]; ok {
+			return v, true
+		}
+		return os.LookupEnv(name)
+	}
+
+	// Expand all scripts first, so a typo in the last one doesn't leave the
+	// org half-updated.
+	scripts := make([]script, len(paths))
+	for i, p := range paths {
+		src, err := os.ReadFile(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading script: %v\n", err.Error())
+			return 1
+		}
+
+		scripts[i], err = expandScript(p, string(src), lookup)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error expanding script: %v\n", err.Error())
+			return 1
+		}
+	}
+
+	con := mustConnect(&opts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, s := range scripts {
+		fmt.Fprintf(os.Stderr, "executing %s\n", s.path)
+		result, err := con.ExecuteAnonymous(ctx, s.body, sfapi.ExecuteAnonymousOptions{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error executing %s: %v\n", s.path, err.Error())
+			return 1
+		}
+
+		if result.Success {
+			continue
+		}
+
+		location := s.location(result)
+		if !result.Compiled {
+			fmt.Fprintf(os.Stderr, "%s: compile error: %s\n", location, result.CompileProblem)
+			return 1
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: %s\n", location, result.ExceptionMessage)
+		if result.ExceptionStackTrace != "" {
+			fmt.Fprintf(os.Stderr, "    %s\n", s.stackTrace(result.ExceptionStackTrace))
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// script is an anonymous Apex script with its placeholders replaced.
+type script struct {
+	path string
+	body string
+	// lines holds the line in the original file of every line of body.
+	lines []int
+}
+
+// line maps a line of the expanded script back to the original file.
+func (s script) line(n int) int {
+	if n < 1 || n > len(s.lines) {
+		return n
+	}
+	return s.lines[n-1]
+}
+
+// location returns where in the original file the script failed as
+// path:line:column. Line and Column of the result are only set for compile
+// problems, the position of an exception is the script's topmost frame in the
+// stack trace. Columns are those of the expanded script.
+func (s script) location(result sfapi.ExecuteAnonymousResult) string {
+	line, column := result.Line, result.Column
+	if result.Compiled {
+		pos := anonymousFrameRegexp.FindStringSubmatch(result.ExceptionStackTrace)
+		if pos == nil {
+			return s.path
+		}
+		line, _ = strconv.Atoi(pos[1])
+		column, _ = strconv.Atoi(pos[2])
+	}
+
+	return s.path + ":" + strconv.Itoa(s.line(line)) + ":" + strconv.Itoa(column)
+}
+
+// stackTrace maps the frames of an exception stack trace that are in the
+// script back to the original file, leaving those in classes and triggers.
+func (s script) stackTrace(st string) string {
+	return anonymousFrameRegexp.ReplaceAllStringFunc(st, func(m string) string {
+		pos := anonymousFrameRegexp.FindStringSubmatch(m)
+		n, err := strconv.Atoi(pos[1])
+		if err != nil {
+			return m
+		}
+		return s.path + ": line " + strconv.Itoa(s.line(n)) + ", column " + pos[2]
+	})
+}
+
+// expandScript replaces the {{name}} placeholders in src with the values
+// returned by lookup. It fails on any placeholder lookup has no value for.
+func expandScript(path, src string, lookup func(string) (string, bool)) (script, error) {
+	s := script{path: path}
+
+	var (
+		sb      strings.Builder
+		missing []string
+	)
+	for i, line := range strings.SplitAfter(src, "\n") {
+		expanded := scriptVarRegexp.ReplaceAllStringFunc(line, func(m string) string {
+			name := scriptVarRegexp.FindStringSubmatch(m)[1]
+			v, ok := lookup(name)
+			if !ok {
+				missing = append(missing, path+":"+strconv.Itoa(i+1)+": undefined variable "+name)
+				return m
+			}
+			return v
+		})
+		sb.WriteString(expanded)
+
+		// Values spanning several lines shift the rest of the script.
+		for range strings.Count(strings.TrimSuffix(expanded, "\n"), "\n") + 1 {
+			s.lines = append(s.lines, i+1)
+		}
+	}
+
+	if len(missing) > 0 {
+		return script{}, errors.New(strings.Join(missing, "\n"))
+	}
+
+	s.body = sb.String()
+	return s, nil
+}
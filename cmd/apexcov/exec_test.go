@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/achere/g-force/pkg/sfapi"
+)
+
+func TestExpandScript(t *testing.T) {
+	vars := map[string]string{
+		"ACCOUNT": "Acme",
+		"LINES":   "System.debug(1);\nSystem.debug(2);",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	src := "// fix {{ ACCOUNT }}\n{{LINES}}\nupdate [SELECT Id FROM Account WHERE Name = '{{ACCOUNT}}'];\n"
+	s, err := expandScript("fix.apex", src, lookup)
+	if err != nil {
+		t.Fatalf("expandScript: %v", err)
+	}
+
+	want := "// fix Acme\nSystem.debug(1);\nSystem.debug(2);\nupdate [SELECT Id FROM Account WHERE Name = 'Acme'];\n"
+	if s.body != want {
+		t.Errorf("body = %q, want %q", s.body, want)
+	}
+
+	for line, wantLine := range map[int]int{1: 1, 2: 2, 3: 2, 4: 3} {
+		if got := s.line(line); got != wantLine {
+			t.Errorf("line(%d) = %d, want %d", line, got, wantLine)
+		}
+	}
+
+	// An exception after the multi-line value is reported on the expanded
+	// line 4, which is line 3 of the file.
+	st := "Class.AccountService.fix: line 12, column 1\nAnonymousBlock: line 4, column 1"
+	wantSt := "Class.AccountService.fix: line 12, column 1\nfix.apex: line 3, column 1"
+	if got := s.stackTrace(st); got != wantSt {
+		t.Errorf("stackTrace = %q, want %q", got, wantSt)
+	}
+
+	locations := []struct {
+		result sfapi.ExecuteAnonymousResult
+		want   string
+	}{
+		{sfapi.ExecuteAnonymousResult{Line: 4, Column: 7, CompileProblem: "Unexpected token"}, "fix.apex:3:7"},
+		{
+			sfapi.ExecuteAnonymousResult{
+				Line: -1, Column: -1, Compiled: true, ExceptionMessage: "System.DmlException", ExceptionStackTrace: st,
+			},
+			"fix.apex:3:1",
+		},
+		{sfapi.ExecuteAnonymousResult{Line: -1, Column: -1, Compiled: true, ExceptionMessage: "System.LimitException"}, "fix.apex"},
+	}
+	for _, l := range locations {
+		if got := s.location(l.result); got != l.want {
+			t.Errorf("location(%+v) = %q, want %q", l.result, got, l.want)
+		}
+	}
+
+	_, err = expandScript("fix.apex", "System.debug(1);\nSystem.debug('{{MISSING}}');", lookup)
+	if err == nil || err.Error() != "fix.apex:2: undefined variable MISSING" {
+		t.Errorf("err = %v, want undefined variable MISSING on line 2", err)
+	}
+}
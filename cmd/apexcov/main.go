@@ -7,7 +7,9 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/achere/g-force/pkg/coverage"
@@ -15,24 +17,90 @@ import (
 )
 
 type config struct {
-	ApiVersion   string `json:"apiVersion"`
-	BaseUrl      string `json:"baseUrl"`
-	ClientId     string `json:"clientId"`
-	ClientSecret string `json:"clientSecret"`
+	ApiVersion     string `json:"apiVersion"`
+	BaseUrl        string `json:"baseUrl"`
+	ClientId       string `json:"clientId"`
+	ClientSecret   string `json:"clientSecret"`
+	Username       string `json:"username"`
+	PrivateKey     string `json:"privateKey"`
+	PrivateKeyFile string `json:"privateKeyFile"`
+	Audience       string `json:"audience"`
+}
+
+type options struct {
+	config       string
+	packages     string
+	targetOrg    string
+	verbose      bool
+	orgCoverage  bool
+	crossCheck   bool
+	strategy     string
+	managedTests bool
 }
 
 func main() {
-	configArg := flag.String(
-		"config",
-		"config.json",
-		"Path to SF org authentication information - config.json",
-	)
-	packagesArg := flag.String(
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "run":
+			os.Exit(run(os.Args[2:]))
+		case "results":
+			os.Exit(fetchResults(os.Args[2:]))
+		case "exec":
+			os.Exit(execScripts(os.Args[2:]))
+		}
+	}
+
+	opts := registerFlags(flag.CommandLine)
+	flag.Parse()
+
+	con, classes, triggers := setup(opts)
+
+	ctx := context.Background()
+	tests, err := coverage.RequestTestsWithOptions(ctx, con, classes, triggers, opts.coverageOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error requesting coverage: %v\n", err.Error())
+		os.Exit(1)
+	}
+
+	if err := checkCoverage(ctx, opts, con, classes, triggers); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	output := strings.Join(tests, " ")
+	fmt.Print(output + " ")
+	os.Exit(0)
+}
+
+func registerFlags(fs *flag.FlagSet) *options {
+	var opts options
+	registerConnectionFlags(fs, &opts)
+	fs.StringVar(
+		&opts.packages,
 		"packages",
 		"package.xml",
 		"Comma-separated list of paths to manifest files - package.xml",
 	)
-	strategyArg := flag.String(
+	fs.BoolVar(
+		&opts.orgCoverage,
+		"org-coverage",
+		false,
+		"Fail if the org-wide coverage is less than the 75% required for deployments to production",
+	)
+	fs.BoolVar(
+		&opts.crossCheck,
+		"cross-check",
+		false,
+		"Warn on stderr about classes and triggers whose computed coverage differs from the one reported by Salesforce",
+	)
+	fs.BoolVar(
+		&opts.managedTests,
+		"managed-tests",
+		false,
+		"Also output tests of installed packages with a namespace that none of the Apex in the manifests has",
+	)
+	fs.StringVar(
+		&opts.strategy,
 		"strategy",
 		"MaxCoverage",
 		`Choose the strategy of getting coverage:
@@ -40,28 +108,53 @@ func main() {
 	- "MaxCoverageWithDeps" to output all tests for the passed in Apex and its dependencies`,
 	)
 
-	flag.Parse()
+	return &opts
+}
+
+func (opts *options) coverageOptions() coverage.Options {
+	return coverage.Options{Strategy: opts.strategy, IncludeManagedTests: opts.managedTests}
+}
 
-	if *strategyArg != coverage.StratMaxCoverage &&
-		*strategyArg != coverage.StratMaxCoverageWithDeps {
+func registerConnectionFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(
+		&opts.config,
+		"config",
+		"config.json",
+		"Path to SF org authentication information - config.json",
+	)
+	fs.StringVar(
+		&opts.targetOrg,
+		"target-org",
+		"",
+		"Alias or username of an org authorized with the sf CLI, or an sfdxAuthUrl; used instead of -config",
+	)
+	fs.BoolVar(
+		&opts.verbose,
+		"verbose",
+		false,
+		"Log every request made to Salesforce to stderr",
+	)
+}
+
+// setup validates the options, connects to the org and reads the Apex from
+// the packages, exiting on any error. It also exits if there is no Apex.
+func setup(opts *options) (*sfapi.Connection, []string, []string) {
+	if opts.strategy != coverage.StratMaxCoverage &&
+		opts.strategy != coverage.StratMaxCoverageWithDeps {
 		fmt.Fprintf(
 			os.Stderr,
 			`unsupported strategy provided: %v; list of supported values: 
 	- MaxCoverage
 	- MaxCoverageWithDeps
 `,
-			*strategyArg,
+			opts.strategy,
 		)
 		os.Exit(1)
 	}
 
-	cfg, err := loadConfig(*configArg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error reading config: %v\n", err.Error())
-		os.Exit(1)
-	}
+	con := mustConnect(opts)
 
-	classes, triggers, err := loadApex(*packagesArg)
+	classes, triggers, err := loadApex(opts.packages)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error reading apex from package: %v\n", err.Error())
 		os.Exit(1)
@@ -71,22 +164,80 @@ func main() {
 		os.Exit(0)
 	}
 
-	con := &sfapi.Connection{
-		ApiVersion:   cfg.ApiVersion,
-		BaseUrl:      cfg.BaseUrl,
-		ClientId:     cfg.ClientId,
-		ClientSecret: cfg.ClientSecret,
-	}
+	return con, classes, triggers
+}
 
-	tests, err := coverage.RequestTestsWithStrategy(context.Background(), *strategyArg, con, classes, triggers)
+// mustConnect connects to the org selected with the connection flags,
+// exiting on error.
+func mustConnect(opts *options) *sfapi.Connection {
+	con, err := connect(opts.config, opts.targetOrg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error requesting coverage: %v\n", err.Error())
+		fmt.Fprintf(os.Stderr, "error setting up connection: %v\n", err.Error())
 		os.Exit(1)
 	}
+	if opts.verbose {
+		con.AfterRequest = append(con.AfterRequest, sfapi.LogHook(slog.New(slog.NewTextHandler(os.Stderr, nil))))
+	}
 
-	output := strings.Join(tests, " ")
-	fmt.Print(output + " ")
-	os.Exit(0)
+	return con
+}
+
+// checkCoverage runs the checks enabled with -org-coverage and -cross-check.
+// Mismatches found by the cross-check are only warned about on stderr.
+func checkCoverage(ctx context.Context, opts *options, con *sfapi.Connection, classes, triggers []string) error {
+	if opts.orgCoverage {
+		if err := coverage.CheckOrgWideCoverage(ctx, con); err != nil {
+			return fmt.Errorf("error checking org-wide coverage: %w", err)
+		}
+	}
+
+	if opts.crossCheck {
+		mismatches, err := coverage.RequestCoverageMismatches(ctx, con, slices.Concat(classes, triggers))
+		if err != nil {
+			return fmt.Errorf("error cross-checking coverage: %w", err)
+		}
+		for _, m := range mismatches {
+			fmt.Fprintf(
+				os.Stderr,
+				"warning: %s has %d of %d lines covered, Salesforce reports %d of %d\n",
+				m.Name, m.LinesCovered, m.Lines, m.SfLinesCovered, m.SfLines,
+			)
+		}
+	}
+
+	return nil
+}
+
+func connect(pathToCfg, targetOrg string) (*sfapi.Connection, error) {
+	var (
+		con *sfapi.Connection
+		err error
+	)
+	if targetOrg != "" {
+		con, err = sfapi.NewConnectionFromSfCli(targetOrg)
+		if err != nil {
+			return nil, fmt.Errorf("sfapi.NewConnectionFromSfCli: %w", err)
+		}
+	} else {
+		cfg, err := loadConfig(pathToCfg)
+		if err != nil {
+			return nil, fmt.Errorf("loadConfig: %w", err)
+		}
+
+		con, err = newConnection(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("newConnection: %w", err)
+		}
+	}
+
+	con.RetryPolicy = sfapi.DefaultRetryPolicy()
+	con.Middleware = append(con.Middleware, sfapi.WithClientName("apexcov"))
+	con.ApiUsageWarning = 0.9
+	con.OnApiUsageWarning = func(u sfapi.ApiUsage) {
+		fmt.Fprintf(os.Stderr, "warning: %d of %d daily API requests used\n", u.Used, u.Max)
+	}
+
+	return con, nil
 }
 
 func loadConfig(pathToCfg string) (config, error) {
@@ -102,13 +253,52 @@ func loadConfig(pathToCfg string) (config, error) {
 		return config{}, fmt.Errorf("decoder.Decode: %w", err)
 	}
 
-	if cfg.ApiVersion == "" || cfg.BaseUrl == "" || cfg.ClientId == "" || cfg.ClientSecret == "" {
+	if cfg.ApiVersion == "" || cfg.BaseUrl == "" || cfg.ClientId == "" {
 		return config{}, errors.New("missing required parameters in config " + pathToCfg)
 	}
 
+	isJwt := cfg.Username != "" && (cfg.PrivateKey != "" || cfg.PrivateKeyFile != "")
+	if !isJwt && cfg.ClientSecret == "" {
+		return config{}, errors.New(
+			"config " + pathToCfg + " must contain either clientSecret or username with privateKey or privateKeyFile",
+		)
+	}
+
 	return cfg, nil
 }
 
+func newConnection(cfg config) (*sfapi.Connection, error) {
+	con := &sfapi.Connection{
+		ApiVersion:   cfg.ApiVersion,
+		BaseUrl:      cfg.BaseUrl,
+		ClientId:     cfg.ClientId,
+		ClientSecret: cfg.ClientSecret,
+		Username:     cfg.Username,
+		Audience:     cfg.Audience,
+	}
+
+	if cfg.Username == "" {
+		return con, nil
+	}
+
+	switch {
+	case cfg.PrivateKey != "":
+		key, err := sfapi.ParsePrivateKey([]byte(cfg.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("sfapi.ParsePrivateKey: %w", err)
+		}
+		con.PrivateKey = key
+	case cfg.PrivateKeyFile != "":
+		key, err := sfapi.LoadPrivateKey(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("sfapi.LoadPrivateKey: %w", err)
+		}
+		con.PrivateKey = key
+	}
+
+	return con, nil
+}
+
 func loadApex(pathToPkg string) ([]string, []string, error) {
 	var (
 		classMap   = make(map[string]bool)
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/achere/g-force/pkg/coverage"
+	"github.com/achere/g-force/pkg/junit"
+	"github.com/achere/g-force/pkg/sfapi"
+)
+
+// run implements `apexcov run`: it runs the tests covering the Apex in the
+// packages, prints their results and checks the coverage they produced.
+func run(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	opts := registerFlags(fs)
+	pollInterval := fs.Duration(
+		"poll-interval",
+		5*time.Second,
+		"How often to check on the test run",
+	)
+	junitArg := fs.String(
+		"junit",
+		"",
+		"Path to write the test results to as JUnit XML",
+	)
+	fs.Parse(args)
+
+	con, classes, triggers := setup(opts)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	tests, err := coverage.RequestTestsWithOptions(ctx, con, classes, triggers, opts.coverageOptions())
+	var covErr *coverage.CoverageError
+	if errors.As(err, &covErr) {
+		tests = covErr.Tests
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "error requesting coverage: %v\n", err.Error())
+		return 1
+	}
+
+	if len(tests) == 0 {
+		fmt.Fprintln(os.Stderr, "error running tests: no tests cover the Apex in the packages")
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "running %d test classes\n", len(tests))
+	results, err := con.RunTests(ctx, sfapi.RunTestsRequest{
+		ClassNames: tests,
+		TestLevel:  sfapi.TestLevelRunSpecifiedTests,
+	}, sfapi.TestRunOptions{
+		PollInterval: *pollInterval,
+		OnProgress:   printProgress,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error running tests: %v\n", err.Error())
+		return 1
+	}
+
+	failures := printTestResults(results)
+	if *junitArg != "" {
+		if err := writeJUnit(*junitArg, results); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing JUnit report: %v\n", err.Error())
+			return 1
+		}
+	}
+
+	exitCode := 0
+	if _, err := coverage.RequestTestsWithOptions(ctx, con, classes, triggers, opts.coverageOptions()); err != nil {
+		fmt.Fprintf(os.Stderr, "error requesting coverage: %v\n", err.Error())
+		exitCode = 1
+	} else if err := checkCoverage(ctx, opts, con, classes, triggers); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		exitCode = 1
+	}
+
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d test methods failed\n", failures, len(results))
+		exitCode = 1
+	}
+
+	return exitCode
+}
+
+// fetchResults implements `apexcov results`: it prints the results of an earlier
+// test run and optionally writes them as JUnit XML.
+func fetchResults(args []string) int {
+	fs := flag.NewFlagSet("results", flag.ExitOnError)
+	var opts options
+	registerConnectionFlags(fs, &opts)
+	jobIdArg := fs.String(
+		"job-id",
+		"",
+		"Id of the AsyncApexJob of the test run",
+	)
+	junitArg := fs.String(
+		"junit",
+		"",
+		"Path to write the test results to as JUnit XML",
+	)
+	fs.Parse(args)
+
+	if *jobIdArg == "" {
+		fmt.Fprintln(os.Stderr, "missing required flag -job-id")
+		return 1
+	}
+
+	con := mustConnect(&opts)
+
+	results, err := con.RequestTestResults(context.Background(), *jobIdArg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error requesting test results: %v\n", err.Error())
+		return 1
+	}
+
+	failures := printTestResults(results)
+	if *junitArg != "" {
+		if err := writeJUnit(*junitArg, results); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing JUnit report: %v\n", err.Error())
+			return 1
+		}
+	}
+
+	if failures > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func writeJUnit(path string, results []sfapi.ApexTestResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("os.Create: %w", err)
+	}
+
+	if err := junit.Write(f, "apexcov", results); err != nil {
+		f.Close()
+		return fmt.Errorf("junit.Write: %w", err)
+	}
+
+	return f.Close()
+}
+
+func printProgress(p sfapi.TestRunProgress) {
+	if p.RunResult == nil {
+		fmt.Fprintf(os.Stderr, "%d test classes queued\n", len(p.QueueItems))
+		return
+	}
+
+	fmt.Fprintf(
+		os.Stderr,
+		"%s: %d/%d test classes, %d/%d methods, %d failed\n",
+		p.RunResult.Status,
+		p.RunResult.ClassesCompleted, p.RunResult.ClassesEnqueued,
+		p.RunResult.MethodsCompleted, p.RunResult.MethodsEnqueued,
+		p.RunResult.MethodsFailed,
+	)
+}
+
+// printTestResults prints a line per test method and returns the number of
+// methods that didn't pass.
+func printTestResults(results []sfapi.ApexTestResult) int {
+	var failures int
+	for _, r := range results {
+		name := r.ApexClass.Name + "." + r.MethodName
+		switch r.Outcome {
+		case sfapi.TestOutcomePass:
+			fmt.Printf("PASS %s (%dms)\n", name, r.RunTime)
+		case sfapi.TestOutcomeSkip:
+			fmt.Printf("SKIP %s\n", name)
+		default:
+			failures++
+			fmt.Printf("FAIL %s: %s\n", name, r.Message)
+			if r.StackTrace != "" {
+				fmt.Printf("    %s\n", r.StackTrace)
+			}
+		}
+	}
+
+	return failures
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"slices"
+	"strings"
 
 	"github.com/achere/g-force/pkg/sfapi"
 	"golang.org/x/sync/errgroup"
@@ -16,7 +17,7 @@ const (
 	StratMaxCoverageWithDeps = "MaxCoverageWithDeps"
 )
 
-type testNamesRequester func(context.Context, coverageDependenciesRequester, []string, []string) ([]string, error)
+type testNamesRequester func(context.Context, coverageDependenciesRequester, []string, []string, Options) ([]string, error)
 
 type coverageDependenciesRequester interface {
 	apexCoverageRequester
@@ -26,6 +27,16 @@ type coverageDependenciesRequester interface {
 type apexCoverageRequester interface {
 	RequestCoverage(ctx context.Context, apexNames []string) ([]sfapi.ApexCodeCoverage, error)
 	RequestApexClasses(ctx context.Context, names []string) ([]sfapi.ApexClass, error)
+	OrgNamespace(ctx context.Context) (string, error)
+}
+
+type coverageAggregateRequester interface {
+	RequestCoverage(ctx context.Context, apexNames []string) ([]sfapi.ApexCodeCoverage, error)
+	RequestCoverageAggregate(ctx context.Context, apexNames []string) ([]sfapi.ApexCodeCoverageAggregate, error)
+}
+
+type orgWideCoverageRequester interface {
+	RequestOrgWideCoverage(ctx context.Context) (sfapi.ApexOrgWideCoverage, error)
 }
 
 var strategyToGetterMap = map[string]testNamesRequester{
@@ -33,6 +44,15 @@ var strategyToGetterMap = map[string]testNamesRequester{
 	StratMaxCoverageWithDeps: requestTestsMaxCoverageWithDeps,
 }
 
+// Options configure RequestTestsWithOptions.
+type Options struct {
+	Strategy string
+	// IncludeManagedTests keeps the tests of namespaced packages other than
+	// the ones of the classes and triggers, which are skipped by default as
+	// they can't be run for a deployment.
+	IncludeManagedTests bool
+}
+
 func RequestTestsWithStrategy(
 	ctx context.Context,
 	strategy string,
@@ -40,13 +60,26 @@ func RequestTestsWithStrategy(
 	classes []string,
 	triggers []string,
 ) ([]string, error) {
-	f := strategyToGetterMap[strategy]
+	return RequestTestsWithOptions(ctx, c, classes, triggers, Options{Strategy: strategy})
+}
+
+// RequestTestsWithOptions is RequestTestsWithStrategy with the strategy and
+// further settings passed in opts. Classes and triggers of namespaced packages
+// are named ns__Name.
+func RequestTestsWithOptions(
+	ctx context.Context,
+	c coverageDependenciesRequester,
+	classes []string,
+	triggers []string,
+	opts Options,
+) ([]string, error) {
+	f := strategyToGetterMap[opts.Strategy]
 
 	if f == nil {
-		return []string{}, errors.New("unsupported strategy provided: " + strategy)
+		return []string{}, errors.New("unsupported strategy provided: " + opts.Strategy)
 	}
 
-	return f(ctx, c, classes, triggers)
+	return f(ctx, c, classes, triggers, opts)
 }
 
 func requestTestsMaxCoverage(
@@ -54,8 +87,9 @@ func requestTestsMaxCoverage(
 	c coverageDependenciesRequester,
 	classes []string,
 	triggers []string,
+	opts Options,
 ) ([]string, error) {
-	testMap, apexMap, tests, err := requestAndParseCoverage(ctx, c, slices.Concat(classes, triggers), classes)
+	testMap, apexMap, tests, err := requestAndParseCoverage(ctx, c, slices.Concat(classes, triggers), classes, opts)
 	if err != nil {
 		return []string{}, fmt.Errorf("requestAndParseCoverage: %w", err)
 	}
@@ -73,11 +107,22 @@ func requestTestsMaxCoverageWithDeps(
 	c coverageDependenciesRequester,
 	classes []string,
 	triggers []string,
+	opts Options,
 ) ([]string, error) {
 	deps, err := c.RequestApexDependencies(ctx, []string{"ApexTrigger", "ApexClass"})
 	if err != nil {
 		return []string{}, fmt.Errorf("t.RequestApexDependencies: %w", err)
 	}
+
+	orgNs, err := c.OrgNamespace(ctx)
+	if err != nil {
+		return []string{}, fmt.Errorf("c.OrgNamespace: %w", err)
+	}
+	for i, d := range deps {
+		deps[i].Namespace = localNamespace(d.Namespace, orgNs)
+		deps[i].RefNamespace = localNamespace(d.RefNamespace, orgNs)
+	}
+
 	apexDeps := ParseDependencies(deps, classes, triggers)
 
 	testMap, apexMap, tests, err := requestAndParseCoverage(
@@ -85,6 +130,7 @@ func requestTestsMaxCoverageWithDeps(
 		c,
 		slices.Concat(classes, triggers, apexDeps),
 		classes,
+		opts,
 	)
 	if err != nil {
 		return []string{}, fmt.Errorf("requestAndParseCoverage: %w", err)
@@ -103,14 +149,25 @@ func requestAndParseCoverage(
 	c apexCoverageRequester,
 	apex []string,
 	classes []string,
+	opts Options,
 ) (map[string]Test, map[string]Apex, []string, error) {
 	g, ctx := errgroup.WithContext(context.Background())
 
 	var (
 		coverages  *[]sfapi.ApexCodeCoverage
 		apiClasses *[]sfapi.ApexClass
+		orgNs      string
 	)
 
+	g.Go(func() error {
+		ns, err := c.OrgNamespace(ctx)
+		if err != nil {
+			return err
+		}
+		orgNs = ns
+		return nil
+	})
+
 	g.Go(func() error {
 		cov, err := c.RequestCoverage(ctx, apex)
 		if err != nil {
@@ -133,27 +190,45 @@ func requestAndParseCoverage(
 		return map[string]Test{}, map[string]Apex{}, []string{}, err
 	}
 
+	if !opts.IncludeManagedTests {
+		*coverages = withoutManagedTests(*coverages, apex, orgNs)
+	}
+
+	// package.xml lists the Apex of the org's own namespace without prefix
+	for i, cov := range *coverages {
+		(*coverages)[i].ApexTestClass.NamespacePrefix = localNamespace(cov.ApexTestClass.NamespacePrefix, orgNs)
+		(*coverages)[i].ApexClassOrTrigger.NamespacePrefix = localNamespace(cov.ApexClassOrTrigger.NamespacePrefix, orgNs)
+	}
+	for i, cls := range *apiClasses {
+		(*apiClasses)[i].NamespacePrefix = localNamespace(cls.NamespacePrefix, orgNs)
+	}
+
 	testMap, apexMap := ParseCoverage(*coverages)
 	tests := findTestClasses(*apiClasses)
 
 	return testMap, apexMap, tests, nil
 }
 
+// Apex is a class or trigger. Name is qualified with the namespace of its
+// package as ns__Name, if any.
 type Apex struct {
-	Id           string
-	IsTrigger    bool
-	Name         string
-	Lines        int
-	Coverage     map[string][]bool
-	LinesCovered int
-	maxLine      int
+	Id              string
+	IsTrigger       bool
+	Name            string
+	NamespacePrefix string
+	Lines           int
+	Coverage        map[string][]bool
+	LinesCovered    int
+	maxLine         int
 }
 
+// Test is a test class. Name is qualified like Apex.Name.
 type Test struct {
-	Id           string
-	Name         string
-	Coverage     map[string][]bool
-	LinesCovered int
+	Id              string
+	Name            string
+	NamespacePrefix string
+	Coverage        map[string][]bool
+	LinesCovered    int
 }
 
 func ParseCoverage(data []sfapi.ApexCodeCoverage) (map[string]Test, map[string]Apex) {
@@ -164,9 +239,9 @@ func ParseCoverage(data []sfapi.ApexCodeCoverage) (map[string]Test, map[string]A
 
 	for _, c := range data {
 		var (
-			testName      = c.ApexTestClass.Name
+			testName      = c.ApexTestClass.QualifiedName()
 			testId        = c.ApexTestClass.Id
-			apexName      = c.ApexClassOrTrigger.Name
+			apexName      = c.ApexClassOrTrigger.QualifiedName()
 			apexId        = c.ApexClassOrTrigger.Id
 			lenCovLines   = len(c.Coverage.CoveredLines)
 			lenUncovLines = len(c.Coverage.UncoveredLines)
@@ -195,10 +270,11 @@ func ParseCoverage(data []sfapi.ApexCodeCoverage) (map[string]Test, map[string]A
 		apex, ok := apexMap[apexId]
 		if !ok {
 			apex = Apex{
-				Id:        apexId,
-				IsTrigger: c.ApexClassOrTrigger.Attributes.Type == "ApexTrigger",
-				Name:      apexName,
-				maxLine:   maxLine,
+				Id:              apexId,
+				IsTrigger:       c.ApexClassOrTrigger.Attributes.Type == "ApexTrigger",
+				Name:            apexName,
+				NamespacePrefix: c.ApexClassOrTrigger.NamespacePrefix,
+				maxLine:         maxLine,
 			}
 			apex.Lines = lenCovLines + lenUncovLines
 
@@ -215,7 +291,7 @@ func ParseCoverage(data []sfapi.ApexCodeCoverage) (map[string]Test, map[string]A
 
 		test, ok := testMap[testId]
 		if !ok {
-			test = Test{Id: testId, Name: testName}
+			test = Test{Id: testId, Name: testName, NamespacePrefix: c.ApexTestClass.NamespacePrefix}
 
 			covMap := make(map[string][]bool)
 			test.Coverage = covMap
@@ -258,26 +334,55 @@ func mergeCoverage(cov1, cov2 []bool) []bool {
 
 func findTestClasses(classes []sfapi.ApexClass) []string {
 	tests := make([]string, 0, len(classes))
-
-outer:
 	for _, c := range classes {
-		for _, a := range c.SymbolTable.TableDeclaration.Annotations {
-			if a.Name == "IsTest" {
-				tests = append(tests, c.Name)
-				continue outer
-			}
-		}
-		for _, m := range c.SymbolTable.TableDeclaration.Modifiers {
-			if m == "testMethod" {
-				tests = append(tests, c.Name)
-				break
-			}
+		if c.SymbolTable.IsTest() {
+			tests = append(tests, c.QualifiedName())
 		}
 	}
 
 	return tests
 }
 
+// withoutManagedTests drops the coverage by tests of namespaced packages
+// other than the ones of apex and the org's own namespace orgNs, e.g. of
+// managed packages installed in the org.
+func withoutManagedTests(coverages []sfapi.ApexCodeCoverage, apex []string, orgNs string) []sfapi.ApexCodeCoverage {
+	namespaces := map[string]bool{"": true, orgNs: true}
+	for _, name := range apex {
+		ns, _ := sfapi.SplitQualifiedName(name)
+		namespaces[ns] = true
+	}
+
+	return slices.DeleteFunc(coverages, func(c sfapi.ApexCodeCoverage) bool {
+		return !namespaces[c.ApexTestClass.NamespacePrefix]
+	})
+}
+
+// localNamespace returns the namespace Apex is named with, which is none for
+// the org's own namespace orgNs.
+func localNamespace(ns, orgNs string) string {
+	if ns == orgNs {
+		return ""
+	}
+	return ns
+}
+
+// ParseReferences maps the names of classes to the names of the classes they
+// reference according to their symbol tables, both qualified as ns__Name.
+func ParseReferences(classes []sfapi.ApexClass) map[string][]string {
+	refs := make(map[string][]string, len(classes))
+	for _, c := range classes {
+		name := c.QualifiedName()
+		refs[name] = slices.DeleteFunc(c.SymbolTable.ReferencedClasses(), func(ref string) bool {
+			return ref == name
+		})
+	}
+
+	return refs
+}
+
+// ParseDependencies returns the Apex the classes and triggers depend on,
+// directly or not. Names are qualified as ns__Name on both sides.
 func ParseDependencies(
 	mcd []sfapi.MetadataComponentDependency,
 	classes, triggers []string,
@@ -288,39 +393,37 @@ func ParseDependencies(
 		deps   []string
 	}
 
+	isRoot := func(apex Apex) bool {
+		if apex.IsTrigger {
+			return slices.Contains(triggers, apex.Name)
+		}
+		return slices.Contains(classes, apex.Name)
+	}
+
 	depMap := make(map[string]Node)
 	for _, d := range mcd {
 		_, ok := depMap[d.RefId]
 		if !ok {
 			apexRef := Apex{
-				Id:        d.RefId,
-				IsTrigger: d.RefType == "ApexTrigger",
-				Name:      d.RefName,
+				Id:              d.RefId,
+				IsTrigger:       d.RefType == "ApexTrigger",
+				Name:            d.RefQualifiedName(),
+				NamespacePrefix: d.RefNamespace,
 			}
-			nodeRef := Node{value: apexRef}
-			depMap[d.RefId] = nodeRef
+			depMap[d.RefId] = Node{value: apexRef, isRoot: isRoot(apexRef)}
 		}
 
 		node, ok := depMap[d.Id]
 		if !ok {
-			isTrigger := d.Type == "ApexTrigger"
 			apex := Apex{
-				Id:        d.Id,
-				IsTrigger: isTrigger,
-				Name:      d.Name,
+				Id:              d.Id,
+				IsTrigger:       d.Type == "ApexTrigger",
+				Name:            d.QualifiedName(),
+				NamespacePrefix: d.Namespace,
 			}
-			var (
-				isRootTrigger = isTrigger && slices.Contains(triggers, d.Name)
-				isRootClass   = !isTrigger && slices.Contains(classes, d.Name)
-			)
-			node = Node{
-				value:  apex,
-				isRoot: isRootTrigger || isRootClass,
-				deps:   []string{d.RefId},
-			}
-		} else {
-			node.deps = append(node.deps, d.RefId)
+			node = Node{value: apex, isRoot: isRoot(apex)}
 		}
+		node.deps = append(node.deps, d.RefId)
 		depMap[d.Id] = node
 	}
 
@@ -360,6 +463,19 @@ func ParseDependencies(
 	return res
 }
 
+// CoverageError is returned when the Apex classes and triggers are missing
+// coverage. Tests are the ones that cover them as far as they are covered and
+// the test classes among them that have no coverage yet, e.g. to run them and
+// check again.
+type CoverageError struct {
+	Tests []string
+	msg   string
+}
+
+func (e *CoverageError) Error() string {
+	return e.msg
+}
+
 func GetTestsMaxCoverage(
 	testMap map[string]Test,
 	apexMap map[string]Apex,
@@ -427,18 +543,121 @@ func GetTestsMaxCoverage(
 		}
 	}
 
+	errTests := res
+	for _, t := range tests {
+		errTests = appendNoDups(errTests, t)
+	}
+
 	if len(errorMsg) > 0 {
-		return []string{}, errors.New(errorMsg)
+		return []string{}, &CoverageError{Tests: errTests, msg: errorMsg}
 	}
 
 	totalCov := math.Ceil(float64(linesCoveredTotal)/float64(linesTotal)*100) / 100
 	if totalCov < 0.75 {
-		return []string{}, errors.New("total coverage is less than 75%: " + fmt.Sprintf("%.2f%%", totalCov*100))
+		return []string{}, &CoverageError{
+			Tests: errTests,
+			msg:   "total coverage is less than 75%: " + fmt.Sprintf("%.2f%%", totalCov*100),
+		}
 	}
 
 	return res, nil
 }
 
+// CheckOrgWideCoverage returns an error if the org-wide coverage is less than
+// the 75% Salesforce requires for deployments to production.
+func CheckOrgWideCoverage(ctx context.Context, c orgWideCoverageRequester) error {
+	cov, err := c.RequestOrgWideCoverage(ctx)
+	if err != nil {
+		return fmt.Errorf("c.RequestOrgWideCoverage: %w", err)
+	}
+
+	if cov.PercentCovered < 75 {
+		return fmt.Errorf("org-wide coverage is less than 75%%: %d%%", cov.PercentCovered)
+	}
+
+	return nil
+}
+
+// CoverageMismatch is a class or trigger whose line counts computed from
+// per-test coverage differ from the totals Salesforce reports.
+type CoverageMismatch struct {
+	Name           string
+	Lines          int
+	LinesCovered   int
+	SfLines        int
+	SfLinesCovered int
+}
+
+// RequestCoverageMismatches computes coverage of apex like
+// RequestTestsWithStrategy does and compares it with ApexCodeCoverageAggregate.
+func RequestCoverageMismatches(
+	ctx context.Context,
+	c coverageAggregateRequester,
+	apex []string,
+) ([]CoverageMismatch, error) {
+	g, ctx := errgroup.WithContext(ctx)
+
+	var (
+		coverages  []sfapi.ApexCodeCoverage
+		aggregates []sfapi.ApexCodeCoverageAggregate
+	)
+
+	g.Go(func() error {
+		cov, err := c.RequestCoverage(ctx, apex)
+		if err != nil {
+			return err
+		}
+		coverages = cov
+		return nil
+	})
+
+	g.Go(func() error {
+		agg, err := c.RequestCoverageAggregate(ctx, apex)
+		if err != nil {
+			return err
+		}
+		aggregates = agg
+		return nil
+	})
+
+	if err := g.Wait(); err != nil {
+		return []CoverageMismatch{}, err
+	}
+
+	_, apexMap := ParseCoverage(coverages)
+
+	return CompareCoverage(apexMap, aggregates), nil
+}
+
+// CompareCoverage returns the classes and triggers of aggregates whose line
+// counts in apexMap are different, sorted by name.
+func CompareCoverage(apexMap map[string]Apex, aggregates []sfapi.ApexCodeCoverageAggregate) []CoverageMismatch {
+	res := make([]CoverageMismatch, 0)
+	for _, agg := range aggregates {
+		var (
+			apex    = apexMap[agg.ApexClassOrTrigger.Id]
+			sfLines = agg.NumLinesCovered + agg.NumLinesUncovered
+		)
+		if apex.Lines == sfLines && apex.LinesCovered == agg.NumLinesCovered {
+			continue
+		}
+
+		res = append(res, CoverageMismatch{
+			Name:           agg.ApexClassOrTrigger.QualifiedName(),
+			Lines:          apex.Lines,
+			LinesCovered:   apex.LinesCovered,
+			SfLines:        sfLines,
+			SfLinesCovered: agg.NumLinesCovered,
+		})
+	}
+
+	slices.SortFunc(res, func(a, b CoverageMismatch) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return res
+}
+
 func appendNoDups(ogSlice []string, item string) []string {
 	m := make(map[string]bool)
 	for _, v := range ogSlice {
@@ -2,9 +2,11 @@ package coverage
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/achere/g-force/pkg/sfapi"
+	"github.com/achere/g-force/pkg/sfapi/sfapitest"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
@@ -239,6 +241,130 @@ func TestRequestMaxCoverageWithDeps(t *testing.T) {
 	}
 }
 
+func TestRequestTestsWithFakeOrg(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+
+	apexClass := sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Class1", "Id": "class1"}
+	org.AddToolingRecords(
+		"ApexCodeCoverage",
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Class1_Test", "Id": "test1"},
+			"ApexClassOrTrigger": apexClass,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1, 2, 3}, "uncoveredLines": []int{4}},
+		},
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Other_Test", "Id": "test2"},
+			"ApexClassOrTrigger": sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Other", "Id": "other"},
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1}, "uncoveredLines": []int{}},
+		},
+	)
+	org.AddToolingRecords("ApexClass", sfapitest.Record{"Name": "Class1", "Id": "class1"})
+
+	tests, err := RequestTestsWithStrategy(context.Background(), StratMaxCoverage, org.Connection(), []string{"Class1"}, []string{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if !slicesEqualIgnoreOrder(tests, []string{"Class1_Test"}) {
+		t.Errorf("Unexpected result: expected %v, got %v\n", []string{"Class1_Test"}, tests)
+	}
+}
+
+func TestCoverageErrorTests(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+
+	org.AddToolingRecords(
+		"ApexCodeCoverage",
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Class1_Test", "Id": "test1"},
+			"ApexClassOrTrigger": sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Class1", "Id": "class1"},
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1}, "uncoveredLines": []int{2, 3, 4}},
+		},
+	)
+	// Class2_Test is in the manifest but hasn't run since it was deployed
+	org.AddToolingRecords(
+		"ApexClass",
+		sfapitest.Record{"Name": "Class1", "Id": "class1"},
+		sfapitest.Record{"Name": "Class2_Test", "SymbolTable": sfapitest.Record{
+			"tableDeclaration": sfapitest.Record{"annotations": []sfapitest.Record{{"name": "IsTest"}}},
+		}},
+	)
+
+	_, err := RequestTestsWithStrategy(
+		context.Background(), StratMaxCoverage, org.Connection(), []string{"Class1", "Class2_Test"}, []string{},
+	)
+
+	var covErr *CoverageError
+	if !errors.As(err, &covErr) {
+		t.Fatalf("Expected CoverageError, got %v", err)
+	}
+	want := []string{"Class1_Test", "Class2_Test"}
+	if !slicesEqualIgnoreOrder(covErr.Tests, want) {
+		t.Errorf("Unexpected tests: expected %v, got %v\n", want, covErr.Tests)
+	}
+}
+
+func TestCheckOrgWideCoverage(t *testing.T) {
+	tests := []struct {
+		name    string
+		percent int
+		wantErr bool
+	}{
+		{name: "Sufficient", percent: 75},
+		{name: "Insufficient", percent: 74, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			org := sfapitest.NewServer()
+			defer org.Close()
+			org.AddToolingRecords("ApexOrgWideCoverage", sfapitest.Record{"PercentCovered": tt.percent})
+
+			err := CheckOrgWideCoverage(context.Background(), org.Connection())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Unexpected error: expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRequestCoverageMismatches(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+
+	class1 := sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Class1", "Id": "class1"}
+	class2 := sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Class2", "Id": "class2"}
+	org.AddToolingRecords(
+		"ApexCodeCoverage",
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Class1_Test", "Id": "test1"},
+			"ApexClassOrTrigger": class1,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1, 2, 3}, "uncoveredLines": []int{4}},
+		},
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Class2_Test", "Id": "test2"},
+			"ApexClassOrTrigger": class2,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1}, "uncoveredLines": []int{2}},
+		},
+	)
+	org.AddToolingRecords(
+		"ApexCodeCoverageAggregate",
+		sfapitest.Record{"ApexClassOrTrigger": class1, "NumLinesCovered": 3, "NumLinesUncovered": 1},
+		sfapitest.Record{"ApexClassOrTrigger": class2, "NumLinesCovered": 2, "NumLinesUncovered": 1},
+	)
+
+	mismatches, err := RequestCoverageMismatches(context.Background(), org.Connection(), []string{"Class1", "Class2"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := []CoverageMismatch{{Name: "Class2", Lines: 2, LinesCovered: 1, SfLines: 3, SfLinesCovered: 2}}
+	if !cmp.Equal(mismatches, expected) {
+		t.Errorf("Unexpected result: %s", cmp.Diff(expected, mismatches))
+	}
+}
+
 func slicesEqualIgnoreOrder(s1, s2 []string) bool {
 	return cmp.Equal(s1, s2, cmpopts.SortSlices(func(e1, e2 string) bool { return e1 < e2 }))
 }
@@ -257,6 +383,195 @@ func (ts RequesterStub) RequestApexDependencies(ctx context.Context, metadataCom
 	return ts.requestApexDependencies(ctx, metadataComponentTypes)
 }
 
+func (ts RequesterStub) OrgNamespace(ctx context.Context) (string, error) {
+	return "", nil
+}
+
 func (ts RequesterStub) RequestApexClasses(ctx context.Context, names []string) ([]sfapi.ApexClass, error) {
 	return ts.requestApexClasses(ctx, names)
 }
+
+func TestFindTestClasses(t *testing.T) {
+	isTest := []sfapi.SymbolTable_Annotation{{Name: "IsTest"}}
+	classes := []sfapi.ApexClass{
+		{Name: "Class1"},
+		{Name: "Class1_Test", SymbolTable: sfapi.ApexClass_SymbolTable{
+			TableDeclaration: sfapi.SymbolTable_Symbol{Annotations: isTest},
+		}},
+		{Name: "Legacy_Test", SymbolTable: sfapi.ApexClass_SymbolTable{
+			Methods: []sfapi.SymbolTable_Method{
+				{SymbolTable_Symbol: sfapi.SymbolTable_Symbol{Name: "test", Modifiers: []string{"static", "testMethod"}}},
+			},
+		}},
+		{Name: "Outer", SymbolTable: sfapi.ApexClass_SymbolTable{
+			InnerClasses: []sfapi.ApexClass_SymbolTable{{
+				Name:    "Inner",
+				Methods: []sfapi.SymbolTable_Method{{SymbolTable_Symbol: sfapi.SymbolTable_Symbol{Annotations: isTest}}},
+			}},
+		}},
+	}
+
+	want := []string{"Class1_Test", "Legacy_Test", "Outer"}
+	if diff := cmp.Diff(want, findTestClasses(classes)); diff != "" {
+		t.Errorf("findTestClasses() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseReferences(t *testing.T) {
+	classes := []sfapi.ApexClass{
+		{Name: "Class1", SymbolTable: sfapi.ApexClass_SymbolTable{
+			ExternalReferences: []sfapi.SymbolTable_ExternalReference{{Name: "Class2"}, {Name: "Class1"}},
+			InnerClasses: []sfapi.ApexClass_SymbolTable{{
+				ExternalReferences: []sfapi.SymbolTable_ExternalReference{{Name: "Logger", Namespace: "nebula"}},
+			}},
+		}},
+		{Name: "Class2"},
+		{Name: "Class1", NamespacePrefix: "acme", SymbolTable: sfapi.ApexClass_SymbolTable{
+			ExternalReferences: []sfapi.SymbolTable_ExternalReference{{Name: "Class1", Namespace: "acme"}},
+		}},
+		{Name: "Util", NamespacePrefix: "own", SymbolTable: sfapi.ApexClass_SymbolTable{
+			ExternalReferences: []sfapi.SymbolTable_ExternalReference{{Name: "Util", Namespace: "own"}, {Name: "Class2"}},
+		}},
+	}
+
+	want := map[string][]string{
+		"Class1":       {"Class2", "nebula__Logger"},
+		"Class2":       {},
+		"acme__Class1": {},
+		"own__Util":    {"Class2"},
+	}
+	if diff := cmp.Diff(want, ParseReferences(classes)); diff != "" {
+		t.Errorf("ParseReferences() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRequestTestsNamespaces(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+
+	var (
+		class1        = sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Class1", "Id": "class1"}
+		managedClass1 = sfapitest.Record{
+			"attributes": map[string]any{"type": "ApexClass"}, "Name": "Class1", "NamespacePrefix": "acme", "Id": "class2",
+		}
+		util   = sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Util", "NamespacePrefix": "own", "Id": "util"}
+		helper = sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Helper", "NamespacePrefix": "own", "Id": "helper"}
+		other  = sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Other", "NamespacePrefix": "own", "Id": "other"}
+	)
+	org.AddToolingRecords(
+		"ApexCodeCoverage",
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Class1_Test", "Id": "test1"},
+			"ApexClassOrTrigger": class1,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1, 2, 3}, "uncoveredLines": []int{4}},
+		},
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Class1_Test", "NamespacePrefix": "acme", "Id": "test2"},
+			"ApexClassOrTrigger": managedClass1,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1}, "uncoveredLines": []int{2, 3, 4}},
+		},
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Trigger_Test", "NamespacePrefix": "acme", "Id": "test3"},
+			"ApexClassOrTrigger": class1,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{4}, "uncoveredLines": []int{1, 2, 3}},
+		},
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Util_Test", "NamespacePrefix": "own", "Id": "test4"},
+			"ApexClassOrTrigger": util,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1}, "uncoveredLines": []int{}},
+		},
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Helper_Test", "NamespacePrefix": "own", "Id": "test5"},
+			"ApexClassOrTrigger": helper,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1}, "uncoveredLines": []int{}},
+		},
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Other_Test", "NamespacePrefix": "own", "Id": "test6"},
+			"ApexClassOrTrigger": other,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1}, "uncoveredLines": []int{}},
+		},
+	)
+
+	org.AddToolingRecords("ApexClass", class1, managedClass1, util, helper, other)
+
+	// Class1 uses own__Helper, while the managed acme__Class1 of the same
+	// name uses own__Other, which is no dependency of the classes.
+	org.AddToolingRecords(
+		"MetadataComponentDependency",
+		sfapitest.Record{
+			"MetadataComponentName": "Class1", "MetadataComponentNamespace": nil,
+			"MetadataComponentId": "class1", "MetadataComponentType": "ApexClass",
+			"RefMetadataComponentName": "Helper", "RefMetadataComponentNamespace": "own",
+			"RefMetadataComponentId": "helper", "RefMetadataComponentType": "ApexClass",
+		},
+		sfapitest.Record{
+			"MetadataComponentName": "Class1", "MetadataComponentNamespace": "acme",
+			"MetadataComponentId": "class2", "MetadataComponentType": "ApexClass",
+			"RefMetadataComponentName": "Other", "RefMetadataComponentNamespace": "own",
+			"RefMetadataComponentId": "other", "RefMetadataComponentType": "ApexClass",
+		},
+	)
+
+	classes := []string{"Class1", "own__Util"}
+	data := []struct {
+		name  string
+		opts  Options
+		tests []string
+	}{
+		{"managed tests skipped", Options{Strategy: StratMaxCoverage}, []string{"Class1_Test", "own__Util_Test"}},
+		{
+			"managed tests included",
+			Options{Strategy: StratMaxCoverage, IncludeManagedTests: true},
+			[]string{"Class1_Test", "acme__Trigger_Test", "own__Util_Test"},
+		},
+		{
+			"dependencies by namespace",
+			Options{Strategy: StratMaxCoverageWithDeps},
+			[]string{"Class1_Test", "own__Helper_Test", "own__Util_Test"},
+		},
+	}
+
+	for _, d := range data {
+		t.Run(d.name, func(t *testing.T) {
+			tests, err := RequestTestsWithOptions(context.Background(), org.Connection(), classes, []string{}, d.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if !slicesEqualIgnoreOrder(tests, d.tests) {
+				t.Errorf("Unexpected result: expected %v, got %v\n", d.tests, tests)
+			}
+		})
+	}
+}
+
+func TestRequestTestsOrgNamespace(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+	org.SetNamespace("own")
+
+	class1 := sfapitest.Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": "Class1", "NamespacePrefix": "own", "Id": "class1"}
+	org.AddToolingRecords(
+		"ApexCodeCoverage",
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Class1_Test", "NamespacePrefix": "own", "Id": "test1"},
+			"ApexClassOrTrigger": class1,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1, 2, 3}, "uncoveredLines": []int{}},
+		},
+		sfapitest.Record{
+			"ApexTestClass":      sfapitest.Record{"Name": "Class1_Test", "NamespacePrefix": "acme", "Id": "test2"},
+			"ApexClassOrTrigger": class1,
+			"Coverage":           sfapitest.Record{"coveredLines": []int{1}, "uncoveredLines": []int{2, 3}},
+		},
+	)
+	org.AddToolingRecords("ApexClass", class1)
+
+	tests, err := RequestTestsWithOptions(
+		context.Background(), org.Connection(), []string{"Class1"}, []string{}, Options{Strategy: StratMaxCoverage},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if want := []string{"Class1_Test"}; !slicesEqualIgnoreOrder(tests, want) {
+		t.Errorf("Unexpected result: expected %v, got %v\n", want, tests)
+	}
+}
@@ -0,0 +1,120 @@
+// Package junit writes Apex test results as JUnit XML, the format CI servers
+// such as GitLab and Jenkins display test reports from.
+package junit
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/achere/g-force/pkg/sfapi"
+)
+
+type testSuites struct {
+	XMLName  xml.Name    `xml:"testsuites"`
+	Name     string      `xml:"name,attr,omitempty"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Suites   []testSuite `xml:"testsuite"`
+}
+
+type testSuite struct {
+	Name      string     `xml:"name,attr"`
+	Tests     int        `xml:"tests,attr"`
+	Failures  int        `xml:"failures,attr"`
+	Errors    int        `xml:"errors,attr"`
+	Skipped   int        `xml:"skipped,attr"`
+	Time      string     `xml:"time,attr"`
+	Timestamp string     `xml:"timestamp,attr,omitempty"`
+	Cases     []testCase `xml:"testcase"`
+
+	runTime int
+}
+
+type testCase struct {
+	Name      string    `xml:"name,attr"`
+	ClassName string    `xml:"classname,attr"`
+	Time      string    `xml:"time,attr"`
+	Failure   *problem  `xml:"failure"`
+	Error     *problem  `xml:"error"`
+	Skipped   *struct{} `xml:"skipped"`
+}
+
+type problem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Write writes results as a JUnit XML report named name, with a testsuite per
+// Apex test class in the order the classes first appear in results. Failed
+// methods are reported as failures, ones that didn't compile as errors.
+func Write(w io.Writer, name string, results []sfapi.ApexTestResult) error {
+	report := testSuites{Name: name}
+
+	suiteIdx := make(map[string]int)
+	var totalTime int
+	for _, r := range results {
+		idx, ok := suiteIdx[r.ApexClass.Name]
+		if !ok {
+			idx = len(report.Suites)
+			suiteIdx[r.ApexClass.Name] = idx
+			report.Suites = append(report.Suites, testSuite{Name: r.ApexClass.Name, Timestamp: r.TestTimestamp})
+		}
+		suite := &report.Suites[idx]
+
+		tc := testCase{Name: r.MethodName, ClassName: r.ApexClass.Name, Time: seconds(r.RunTime)}
+		switch r.Outcome {
+		case sfapi.TestOutcomePass:
+		case sfapi.TestOutcomeSkip:
+			tc.Skipped = &struct{}{}
+			suite.Skipped++
+		case sfapi.TestOutcomeCompileFail:
+			tc.Error = &problem{Message: r.Message, Type: r.Outcome, Text: r.StackTrace}
+			suite.Errors++
+		default:
+			tc.Failure = &problem{Message: r.Message, Type: r.Outcome, Text: r.StackTrace}
+			suite.Failures++
+		}
+
+		suite.Tests++
+		suite.runTime += r.RunTime
+		suite.Cases = append(suite.Cases, tc)
+		totalTime += r.RunTime
+	}
+
+	for i := range report.Suites {
+		suite := &report.Suites[i]
+		suite.Time = seconds(suite.runTime)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+	}
+	report.Time = seconds(totalTime)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("io.WriteString: %w", err)
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("xml.Encoder.Encode: %w", err)
+	}
+
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("io.WriteString: %w", err)
+	}
+
+	return nil
+}
+
+// seconds formats a run time in milliseconds as JUnit expects it.
+func seconds(ms int) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', 3, 64)
+}
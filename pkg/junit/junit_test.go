@@ -0,0 +1,44 @@
+package junit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/achere/g-force/pkg/sfapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	class1 := sfapi.ApexTestResult_ApexClass{Name: "Class1_Test"}
+	class2 := sfapi.ApexTestResult_ApexClass{Name: "Class2_Test"}
+	results := []sfapi.ApexTestResult{
+		{ApexClass: class1, MethodName: "passes", Outcome: sfapi.TestOutcomePass, RunTime: 120, TestTimestamp: "2024-01-02T03:04:05.000+0000"},
+		{ApexClass: class2, MethodName: "fails", Outcome: sfapi.TestOutcomeFail, RunTime: 5, Message: "Assertion Failed: 1 <> 2", StackTrace: "Class.Class2_Test.fails: line 3"},
+		{ApexClass: class1, MethodName: "skipped", Outcome: sfapi.TestOutcomeSkip},
+		{ApexClass: class2, MethodName: "broken", Outcome: sfapi.TestOutcomeCompileFail, Message: "Variable does not exist: x"},
+	}
+
+	var sb strings.Builder
+	require.NoError(t, Write(&sb, "apexcov", results))
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="apexcov" tests="4" failures="1" errors="1" skipped="1" time="0.125">
+  <testsuite name="Class1_Test" tests="2" failures="0" errors="0" skipped="1" time="0.120" timestamp="2024-01-02T03:04:05.000+0000">
+    <testcase name="passes" classname="Class1_Test" time="0.120"></testcase>
+    <testcase name="skipped" classname="Class1_Test" time="0.000">
+      <skipped></skipped>
+    </testcase>
+  </testsuite>
+  <testsuite name="Class2_Test" tests="2" failures="1" errors="1" skipped="0" time="0.005">
+    <testcase name="fails" classname="Class2_Test" time="0.005">
+      <failure message="Assertion Failed: 1 &lt;&gt; 2" type="Fail">Class.Class2_Test.fails: line 3</failure>
+    </testcase>
+    <testcase name="broken" classname="Class2_Test" time="0.000">
+      <error message="Variable does not exist: x" type="CompileFail"></error>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	assert.Equal(t, expected, sb.String())
+}
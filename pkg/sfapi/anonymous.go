@@ -0,0 +1,238 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExecuteAnonymousResult is the outcome of running anonymous Apex. Line and
+// Column locate the compile problem, they are -1 if the script compiled.
+// Log is only set if it was requested.
+type ExecuteAnonymousResult struct {
+	Line                int    `json:"line" xml:"line"`
+	Column              int    `json:"column" xml:"column"`
+	Compiled            bool   `json:"compiled" xml:"compiled"`
+	Success             bool   `json:"success" xml:"success"`
+	CompileProblem      string `json:"compileProblem" xml:"compileProblem"`
+	ExceptionStackTrace string `json:"exceptionStackTrace" xml:"exceptionStackTrace"`
+	ExceptionMessage    string `json:"exceptionMessage" xml:"exceptionMessage"`
+	Log                 string `json:"-" xml:"-"`
+}
+
+// Err describes why the script didn't succeed, it's nil if it did.
+func (r ExecuteAnonymousResult) Err() error {
+	if r.Success {
+		return nil
+	}
+
+	if r.Compiled {
+		msg := "error on line " +
+			strconv.Itoa(r.Line) + ":" + strconv.Itoa(r.Column) + " - " +
+			r.ExceptionMessage + " " + r.ExceptionStackTrace
+		return errors.New(msg)
+	}
+
+	return errors.New("didn't compile: " + r.CompileProblem)
+}
+
+type ExecuteAnonymousOptions struct {
+	// Log requests the debug log of the execution.
+	Log bool
+}
+
+// ExecuteAnonymous runs anonymous Apex. The returned error is only set if the
+// script couldn't be run or its log couldn't be retrieved, compile problems
+// and exceptions are reported in the result.
+//
+// Scripts are sent with the Tooling API unless they are too long for a URL,
+// in which case the SOAP Apex API is used instead. Logs of Tooling API
+// executions are captured with a temporary trace flag, see StartDebugLogs.
+func (c *Connection) ExecuteAnonymous(
+	ctx context.Context,
+	body string,
+	opts ExecuteAnonymousOptions,
+) (ExecuteAnonymousResult, error) {
+	path := "/services/data/v" + c.ApiVersion + "/tooling/executeAnonymous/?anonymousBody=" + url.QueryEscape(body)
+	if len(path) > maxQueryLength {
+		return c.executeAnonymousSoap(ctx, body, opts.Log)
+	}
+
+	if !opts.Log {
+		return c.executeAnonymousRest(ctx, path)
+	}
+
+	return c.executeAnonymousRestWithLog(ctx, path)
+}
+
+// ExecuteAnonymousRest runs anonymous Apex and returns an error if it didn't
+// compile or threw an exception, see ExecuteAnonymous.
+func (c *Connection) ExecuteAnonymousRest(ctx context.Context, body string) error {
+	result, err := c.ExecuteAnonymous(ctx, body, ExecuteAnonymousOptions{})
+	if err != nil {
+		return err
+	}
+
+	return result.Err()
+}
+
+// ExecuteAnonymousWithLog runs anonymous Apex like ExecuteAnonymousRest and
+// returns the debug log of the execution, also if it failed.
+func (c *Connection) ExecuteAnonymousWithLog(ctx context.Context, body string) (string, error) {
+	result, err := c.ExecuteAnonymous(ctx, body, ExecuteAnonymousOptions{Log: true})
+	if err != nil {
+		return result.Log, err
+	}
+
+	return result.Log, result.Err()
+}
+
+func (c *Connection) executeAnonymousRest(ctx context.Context, path string) (ExecuteAnonymousResult, error) {
+	req, err := http.NewRequest(http.MethodGet, c.BaseUrl+path, nil)
+	if err != nil {
+		return ExecuteAnonymousResult{}, fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	// Retrying could run the script twice if only the response got lost
+	respBody, err := c.DoRequest(WithoutRetries(ctx), req)
+	if err != nil {
+		return ExecuteAnonymousResult{}, fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	var result ExecuteAnonymousResult
+	err = json.Unmarshal(respBody, &result)
+	if err != nil {
+		return ExecuteAnonymousResult{}, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return result, nil
+}
+
+// executeAnonymousRestWithLog enables debug logs for the running user around
+// the execution and downloads its log. The user is identified first if the
+// Connection doesn't know it yet.
+func (c *Connection) executeAnonymousRestWithLog(ctx context.Context, path string) (ExecuteAnonymousResult, error) {
+	userId, err := c.runningUserId(ctx)
+	if err != nil {
+		return ExecuteAnonymousResult{}, fmt.Errorf("c.runningUserId: %w", err)
+	}
+
+	session, err := c.StartDebugLogs(ctx, userId, 0)
+	if err != nil {
+		return ExecuteAnonymousResult{}, fmt.Errorf("c.StartDebugLogs: %w", err)
+	}
+
+	// Log timestamps are truncated to seconds and the clocks may differ a bit.
+	since := time.Now().Add(-time.Minute)
+	result, errExec := c.executeAnonymousRest(ctx, path)
+
+	var errLog error
+	if errExec == nil {
+		result.Log, errLog = c.requestExecuteAnonymousLog(ctx, userId, since)
+		if errLog != nil {
+			errLog = fmt.Errorf("c.requestExecuteAnonymousLog: %w", errLog)
+		}
+	}
+
+	errStop := c.StopDebugLogs(ctx, session)
+	if errStop != nil {
+		errStop = fmt.Errorf("c.StopDebugLogs: %w", errStop)
+	}
+
+	return result, errors.Join(errExec, errLog, errStop)
+}
+
+// executeAnonymousSoap runs anonymous Apex with the SOAP Apex API, which takes
+// the script in the request body. Its log is returned in a response header.
+// The access token is part of the body as well, so an expired session is
+// answered with a fault rather than a 401 and the request is rebuilt with a
+// new token for the retry.
+func (c *Connection) executeAnonymousSoap(ctx context.Context, body string, withLog bool) (ExecuteAnonymousResult, error) {
+	token, err := c.getAccessToken(ctx)
+	if err != nil {
+		return ExecuteAnonymousResult{}, fmt.Errorf("c.getAccessToken: %w", err)
+	}
+
+	respBody, err := c.sendExecuteAnonymousSoap(ctx, token, body, withLog)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.HasErrorCode("INVALID_SESSION_ID") {
+		token, err = c.refreshToken(ctx, token)
+		if err != nil {
+			return ExecuteAnonymousResult{}, fmt.Errorf("%w (token refresh failed: %v)", apiErr, err)
+		}
+		respBody, err = c.sendExecuteAnonymousSoap(ctx, token, body, withLog)
+	}
+	if err != nil {
+		return ExecuteAnonymousResult{}, fmt.Errorf("c.sendExecuteAnonymousSoap: %w", err)
+	}
+
+	var parsedResponse struct {
+		DebugLog string                 `xml:"Header>DebuggingInfo>debugLog"`
+		Result   ExecuteAnonymousResult `xml:"Body>executeAnonymousResponse>result"`
+	}
+	if err := xml.Unmarshal(respBody, &parsedResponse); err != nil {
+		return ExecuteAnonymousResult{}, fmt.Errorf("xml.Unmarshal: %w", err)
+	}
+
+	result := parsedResponse.Result
+	result.Log = parsedResponse.DebugLog
+
+	return result, nil
+}
+
+func (c *Connection) sendExecuteAnonymousSoap(ctx context.Context, token *Token, body string, withLog bool) ([]byte, error) {
+	var debugging string
+	if withLog {
+		debugging = `<apex:DebuggingHeader>` +
+			soapLogCategory("Apex_code", "FINEST") +
+			soapLogCategory("Apex_profiling", "INFO") +
+			soapLogCategory("Callout", "INFO") +
+			soapLogCategory("Db", "INFO") +
+			soapLogCategory("System", "DEBUG") +
+			soapLogCategory("Validation", "INFO") +
+			soapLogCategory("Visualforce", "INFO") +
+			soapLogCategory("Workflow", "INFO") +
+			`</apex:DebuggingHeader>`
+	}
+
+	envelope := xml.Header +
+		`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:apex="http://soap.sforce.com/2006/08/apex">` +
+		`<soapenv:Header>` +
+		`<apex:SessionHeader><apex:sessionId>` + escapeXml(token.AccessToken) + `</apex:sessionId></apex:SessionHeader>` +
+		debugging +
+		`</soapenv:Header>` +
+		`<soapenv:Body><apex:executeAnonymous><apex:String>` + escapeXml(body) + `</apex:String></apex:executeAnonymous></soapenv:Body>` +
+		`</soapenv:Envelope>`
+
+	req, err := http.NewRequest(http.MethodPost, c.BaseUrl+"/services/Soap/s/"+c.ApiVersion, strings.NewReader(envelope))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/xml; charset=UTF-8")
+	req.Header.Set("SOAPAction", `""`)
+
+	// Retrying could run the script twice if only the response got lost
+	respBody, err := c.DoRequest(WithoutRetries(ctx), req)
+	if err != nil {
+		return nil, fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	return respBody, nil
+}
+
+func soapLogCategory(category, level string) string {
+	return `<apex:categories><apex:category>` + category + `</apex:category><apex:level>` + level + `</apex:level></apex:categories>`
+}
+
+func escapeXml(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
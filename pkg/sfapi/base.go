@@ -2,40 +2,147 @@ package sfapi
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
+// Connection is safe for concurrent use once its exported fields are set.
+// Concurrent requests that find no valid access token share a single token request.
 type Connection struct {
 	ApiVersion   string
 	BaseUrl      string
 	OrgId        string
+	UserId       string
+	IsSandbox    bool
 	ClientId     string
 	ClientSecret string
-	accessToken  string
+	Username     string
+	PrivateKey   *rsa.PrivateKey
+	Audience     string
+	TokenSource  TokenSource
 	HttpClient   *http.Client
+	RetryPolicy  *RetryPolicy
+	// ApiUsageLimit is the share of daily API requests, between 0 and 1,
+	// after which requests are refused with ErrApiUsageLimit. Zero disables it.
+	ApiUsageLimit float64
+	// OnApiUsageWarning is called once when the share of daily API requests
+	// used reaches ApiUsageWarning.
+	ApiUsageWarning   float64
+	OnApiUsageWarning func(ApiUsage)
+	Middleware        []Middleware
+	// AfterRequest hooks are called after every HTTP exchange DoRequest makes.
+	AfterRequest []func(RequestInfo)
+
+	mu             sync.Mutex
+	token          *Token
+	tokenGroup     singleflight.Group
+	apiUsage       ApiUsage
+	apiUsageWarned bool
+	orgNamespace   *string
 }
 
 type TokenResponse struct {
 	AccessToken string `json:"access_token"`
+	InstanceUrl string `json:"instance_url,omitempty"`
+	Id          string `json:"id,omitempty"`
+	IssuedAt    string `json:"issued_at,omitempty"`
+	Signature   string `json:"signature,omitempty"`
+	ExpiresIn   int    `json:"expires_in,omitempty"`
+}
+
+func (c *Connection) getAccessToken(ctx context.Context) (*Token, error) {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+
+	if token.Valid() {
+		return token, nil
+	}
+
+	return c.refreshToken(ctx, token)
+}
+
+// refreshToken replaces the stale token with a new one from the TokenSource.
+// If another goroutine has already replaced it, the current token is returned
+// without another request.
+func (c *Connection) refreshToken(ctx context.Context, stale *Token) (*Token, error) {
+	ch := c.tokenGroup.DoChan("token", func() (any, error) {
+		c.mu.Lock()
+		current := c.token
+		c.mu.Unlock()
+
+		if current != stale && current.Valid() {
+			return current, nil
+		}
+
+		// The request is shared by all waiting callers, so it must not be
+		// canceled together with the context of the one that started it.
+		token, err := c.tokenSource().Token(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("TokenSource.Token: %w", err)
+		}
+
+		c.mu.Lock()
+		c.token = token
+		c.mu.Unlock()
+
+		return token, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("context canceled: %w", ctx.Err())
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*Token), nil
+	}
 }
 
-func (c *Connection) getAccessToken(ctx context.Context) (string, error) {
-	if c.accessToken != "" {
-		return c.accessToken, nil
+func (c *Connection) httpClient() *http.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.HttpClient == nil {
+		c.HttpClient = &http.Client{}
 	}
 
-	return c.refreshToken(ctx)
+	return c.HttpClient
 }
 
-func (c *Connection) refreshToken(ctx context.Context) (string, error) {
-	return c.getTokenClientCredentials(ctx)
+// tokenSource returns the TokenSource set on the Connection or, if there is
+// none, one of the built-in flows configured with the Connection's fields.
+func (c *Connection) tokenSource() TokenSource {
+	if c.TokenSource != nil {
+		return c.TokenSource
+	}
+
+	if c.PrivateKey != nil {
+		return &JwtBearerSource{
+			BaseUrl:    c.BaseUrl,
+			ClientId:   c.ClientId,
+			Username:   c.Username,
+			PrivateKey: c.PrivateKey,
+			Audience:   c.Audience,
+			HttpClient: c.httpClient(),
+		}
+	}
+
+	return &ClientCredentialsSource{
+		BaseUrl:      c.BaseUrl,
+		ClientId:     c.ClientId,
+		ClientSecret: c.ClientSecret,
+		HttpClient:   c.httpClient(),
+	}
 }
 
 func (c *Connection) DoRequest(ctx context.Context, req *http.Request) ([]byte, error) {
@@ -43,100 +150,137 @@ func (c *Connection) DoRequest(ctx context.Context, req *http.Request) ([]byte,
 		return []byte{}, fmt.Errorf("context canceled: %w", err)
 	}
 
-	token, err := c.getAccessToken(ctx)
-	if err != nil {
-		return []byte{}, fmt.Errorf("c.getAccessToken: %w", err)
+	if err := c.checkApiUsage(req); err != nil {
+		return []byte{}, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
 
-	req = req.WithContext(ctx)
-	if c.HttpClient == nil {
-		c.HttpClient = &http.Client{}
+	for attempt := 1; ; attempt++ {
+		status, respBody, err := c.doRequestOnce(ctx, req)
+		if err == nil {
+			return respBody, nil
+		}
+
+		if !c.RetryPolicy.shouldRetry(ctx, req, attempt, status, respBody, err) {
+			return respBody, err
+		}
+
+		if errWait := c.RetryPolicy.wait(ctx, attempt); errWait != nil {
+			return respBody, err
+		}
+
+		retryReq, errReplay := replayRequest(ctx, req)
+		if errReplay != nil {
+			return respBody, err
+		}
+		req = retryReq
 	}
+}
 
-	resp, err := c.HttpClient.Do(req)
+// doRequestOnce makes a single attempt at req, retrying it only if the access
+// token has expired. The status is 0 if no response was received.
+func (c *Connection) doRequestOnce(ctx context.Context, req *http.Request) (int, []byte, error) {
+	token, err := c.getAccessToken(ctx)
 	if err != nil {
-		return []byte{}, fmt.Errorf("c.httpClient.Do: %w", err)
+		return 0, []byte{}, fmt.Errorf("c.getAccessToken: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 403 {
-		token, errToken := c.refreshToken(ctx)
-		if errToken != nil {
-			return []byte{}, fmt.Errorf("c.refreshToken: %w", errToken)
+	status, respBody, err := c.send(ctx, req, token)
+	if err != nil {
+		return 0, []byte{}, err
+	}
+
+	if isSessionExpired(status, respBody) {
+		errSession := newAPIError(status, RedactUrl(req.URL), respBody)
+		retryReq, err := replayRequest(ctx, req)
+		if err != nil {
+			return status, respBody, fmt.Errorf("%w (not retried: %v)", errSession, err)
 		}
-		req.Header.Set("Authorization", "Bearer "+token)
 
-		newReq := req.Clone(ctx)
-		newResp, errToken := c.HttpClient.Do(newReq)
-		if errToken != nil {
-			return []byte{}, fmt.Errorf("c.httpClient.Do: %w", errToken)
+		token, err = c.refreshToken(ctx, token)
+		if err != nil {
+			return status, respBody, fmt.Errorf("%w (token refresh failed: %v)", errSession, err)
 		}
-		defer newResp.Body.Close()
 
-		if newResp.StatusCode == 200 {
-			resp = newResp
+		retryStatus, retryBody, err := c.send(ctx, retryReq, token)
+		if err != nil {
+			return 0, respBody, fmt.Errorf("%w (retry failed: %v)", errSession, err)
+		}
+		if !isSuccess(retryStatus) {
+			return retryStatus, retryBody, fmt.Errorf(
+				"%w (retry failed: %v)", errSession, newAPIError(retryStatus, RedactUrl(retryReq.URL), retryBody),
+			)
 		}
-	}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return []byte{}, fmt.Errorf("io.ReadAll: %w", err)
+		return retryStatus, retryBody, nil
 	}
 
-	if resp.StatusCode == 200 {
-		return respBody, nil
+	if isSuccess(status) {
+		return status, respBody, nil
 	}
 
-	return respBody, fmt.Errorf(
-		"unexpected status code returned: %s, body: %s",
-		strconv.Itoa(resp.StatusCode),
-		string(respBody),
-	)
+	return status, respBody, newAPIError(status, RedactUrl(req.URL), respBody)
 }
 
-func (c *Connection) getTokenClientCredentials(ctx context.Context) (string, error) {
-	if err := ctx.Err(); err != nil {
-		return "", fmt.Errorf("context canceled: %w", err)
-	}
+func (c *Connection) send(ctx context.Context, req *http.Request, token *Token) (int, []byte, error) {
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	c.routeToInstance(req, token)
 
-	formData := url.Values{}
-	formData.Set("grant_type", "client_credentials")
-	formData.Set("client_id", c.ClientId)
-	formData.Set("client_secret", c.ClientSecret)
+	req = req.WithContext(ctx)
+	start := time.Now()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseUrl+"/services/oauth2/token", nil)
+	resp, err := c.roundTrip()(req)
 	if err != nil {
-		return "", fmt.Errorf("http.NewRequestWithContext: %w", err)
+		c.afterRequest(req, nil, start, err)
+		return 0, nil, fmt.Errorf("c.httpClient.Do: %w", err)
 	}
+	defer resp.Body.Close()
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Body = io.NopCloser(strings.NewReader(formData.Encode()))
+	c.recordApiUsage(resp.Header)
 
-	if c.HttpClient == nil {
-		c.HttpClient = &http.Client{}
+	respBody, err := io.ReadAll(resp.Body)
+	c.afterRequest(req, resp, start, err)
+	if err != nil {
+		return 0, nil, fmt.Errorf("io.ReadAll: %w", err)
 	}
 
-	resp, err := c.HttpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("httpClient.Do: %w", err)
+	return resp.StatusCode, respBody, nil
+}
+
+// replayRequest copies req for another attempt, rewinding the already consumed
+// body with GetBody which http.NewRequest sets for in-memory bodies.
+func replayRequest(ctx context.Context, req *http.Request) (*http.Request, error) {
+	newReq := req.Clone(ctx)
+	if req.Body == nil || req.Body == http.NoBody {
+		return newReq, nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return "", errors.New("token request returned " + strconv.Itoa(resp.StatusCode))
+	if req.GetBody == nil {
+		return nil, errors.New("request body can't be replayed as GetBody isn't set")
 	}
 
-	respBody, err := io.ReadAll(resp.Body)
+	body, err := req.GetBody()
 	if err != nil {
-		return "", fmt.Errorf("io.ReadAll: %w", err)
+		return nil, fmt.Errorf("req.GetBody: %w", err)
 	}
+	newReq.Body = body
 
-	var token TokenResponse
-	err = json.Unmarshal(respBody, &token)
-	if err != nil {
-		return "", fmt.Errorf("json.Unmarshal: %w", err)
+	return newReq, nil
+}
+
+// isSessionExpired reports whether Salesforce rejected the access token,
+// which it does with 401 and an INVALID_SESSION_ID error code.
+func isSessionExpired(status int, body []byte) bool {
+	if status != http.StatusUnauthorized {
+		return false
 	}
 
-	return token.AccessToken, nil
+	items := parseErrorItems(body)
+
+	return len(items) == 0 || slices.ContainsFunc(items, func(item APIErrorItem) bool {
+		return item.ErrorCode == "INVALID_SESSION_ID"
+	})
+}
+
+func isSuccess(status int) bool {
+	return status >= 200 && status < 300
 }
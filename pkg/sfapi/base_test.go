@@ -0,0 +1,133 @@
+package sfapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoRequestConcurrentTokenRefresh(t *testing.T) {
+	const parallel = 20
+
+	var tokenRequests atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/oauth2/token" {
+			tokenRequests.Add(1)
+			time.Sleep(50 * time.Millisecond)
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "token"})
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer mockServer.Close()
+
+	c := &Connection{
+		BaseUrl:    mockServer.URL,
+		ApiVersion: "60.0",
+		HttpClient: mockServer.Client(),
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, parallel)
+	for i := range parallel {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, mockServer.URL+"/services/data/v60.0/", nil)
+			_, errs[i] = c.DoRequest(context.Background(), req)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), tokenRequests.Load())
+}
+
+func TestDoRequestSessionExpired(t *testing.T) {
+	const body = `{"allOrNone":false,"records":[]}`
+
+	tests := []struct {
+		name       string
+		failRetry  bool
+		wantErr    bool
+		wantTokens int32
+	}{
+		{
+			name:       "Retried with new token",
+			wantTokens: 2,
+		},
+		{
+			name:       "Retry fails",
+			failRetry:  true,
+			wantErr:    true,
+			wantTokens: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tokenRequests atomic.Int32
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/services/oauth2/token" {
+					n := tokenRequests.Add(1)
+					json.NewEncoder(w).Encode(TokenResponse{AccessToken: "token" + strconv.Itoa(int(n))})
+					return
+				}
+
+				reqBody, _ := io.ReadAll(r.Body)
+				assert.Equal(t, body, string(reqBody))
+
+				if r.Header.Get("Authorization") == "Bearer token1" {
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte(`[{"message":"Session expired or invalid","errorCode":"INVALID_SESSION_ID"}]`))
+					return
+				}
+				if tt.failRetry {
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write([]byte(`[{"message":"boom","errorCode":"UNKNOWN_EXCEPTION"}]`))
+					return
+				}
+				w.Write([]byte("[]"))
+			}))
+			defer mockServer.Close()
+
+			c := &Connection{
+				BaseUrl:    mockServer.URL,
+				ApiVersion: "60.0",
+				HttpClient: mockServer.Client(),
+			}
+
+			req, _ := http.NewRequest(
+				http.MethodPost,
+				mockServer.URL+"/services/data/v60.0/composite/sobjects",
+				bytes.NewBufferString(body),
+			)
+			_, err := c.DoRequest(context.Background(), req)
+
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "INVALID_SESSION_ID")
+				assert.ErrorContains(t, err, "UNKNOWN_EXCEPTION")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantTokens, tokenRequests.Load())
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package sfapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achere/g-force/pkg/sfapi/soql"
+)
+
+const (
+	LogTypeUserDebug    = "USER_DEBUG"
+	LogTypeDeveloperLog = "DEVELOPER_LOG"
+)
+
+const (
+	sfDateTimeLayout      = "2006-01-02T15:04:05.000-0700"
+	debugLevelNamePrefix  = "sfapi_"
+	defaultDebugLogPeriod = 30 * time.Minute
+	// maxDebugLogPeriod is the longest a trace flag may be active.
+	maxDebugLogPeriod = 24 * time.Hour
+)
+
+type DebugLevel struct {
+	Id            string `json:"Id,omitempty"`
+	DeveloperName string `json:"DeveloperName"`
+	MasterLabel   string `json:"MasterLabel"`
+	ApexCode      string `json:"ApexCode"`
+	ApexProfiling string `json:"ApexProfiling"`
+	Callout       string `json:"Callout"`
+	Database      string `json:"Database"`
+	System        string `json:"System"`
+	Validation    string `json:"Validation"`
+	Visualforce   string `json:"Visualforce"`
+	Workflow      string `json:"Workflow"`
+}
+
+type TraceFlag struct {
+	Id             string `json:"Id,omitempty"`
+	TracedEntityId string `json:"TracedEntityId"`
+	DebugLevelId   string `json:"DebugLevelId"`
+	LogType        string `json:"LogType"`
+	StartDate      string `json:"StartDate,omitempty"`
+	ExpirationDate string `json:"ExpirationDate"`
+}
+
+type ApexLog struct {
+	Id                   string `json:"Id"`
+	LogUserId            string `json:"LogUserId"`
+	LogLength            int    `json:"LogLength"`
+	Operation            string `json:"Operation"`
+	Request              string `json:"Request"`
+	Status               string `json:"Status"`
+	StartTime            string `json:"StartTime"`
+	DurationMilliseconds int    `json:"DurationMilliseconds"`
+}
+
+// DebugLogSession is a trace flag that makes Salesforce record debug logs of
+// a user, see StartDebugLogs.
+type DebugLogSession struct {
+	TraceFlag TraceFlag
+
+	// created is false if the session reuses a trace flag that was already
+	// active and must not be deleted when it ends.
+	created bool
+	// reused is the trace flag as it was before the session took it over,
+	// restored when the session ends.
+	reused *TraceFlag
+}
+
+// StartDebugLogs makes Salesforce record debug logs of userId for duration,
+// 30 minutes if it's 0 and at most 24 hours. It creates a DebugLevel logging
+// Apex code at FINEST and a trace flag using it. As Salesforce allows only one
+// active USER_DEBUG trace flag per user, an existing one is taken over
+// instead: it's switched to the DebugLevel and extended if it expires sooner.
+// The session must be ended with StopDebugLogs, which restores it.
+func (c *Connection) StartDebugLogs(ctx context.Context, userId string, duration time.Duration) (*DebugLogSession, error) {
+	if duration <= 0 {
+		duration = defaultDebugLogPeriod
+	}
+	if duration > maxDebugLogPeriod {
+		return nil, errors.New("debug logs can be recorded for at most 24 hours, not " + duration.String())
+	}
+	now := time.Now()
+
+	query := soql.Select("Id", "TracedEntityId", "DebugLevelId", "LogType", "StartDate", "ExpirationDate").
+		From("TraceFlag").
+		Where(soql.And(
+			soql.Eq("TracedEntityId", userId),
+			soql.Eq("LogType", LogTypeUserDebug),
+			soql.Gt("ExpirationDate", now),
+		)).
+		OrderByDesc("ExpirationDate").
+		Tooling()
+
+	flags, err := queryAll[TraceFlag](c, ctx, query.Path(c.ApiVersion))
+	if err != nil {
+		return nil, fmt.Errorf("queryAll: %w", err)
+	}
+
+	name := debugLevelNamePrefix + strconv.FormatInt(now.UnixNano(), 10)
+	debugLevelId, err := c.CreateToolingRecord(ctx, "DebugLevel", DebugLevel{
+		DeveloperName: name,
+		MasterLabel:   name,
+		ApexCode:      "FINEST",
+		ApexProfiling: "INFO",
+		Callout:       "INFO",
+		Database:      "INFO",
+		System:        "DEBUG",
+		Validation:    "INFO",
+		Visualforce:   "INFO",
+		Workflow:      "INFO",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("c.CreateToolingRecord: %w", err)
+	}
+
+	if len(flags) > 0 {
+		flag, err := c.takeOverTraceFlag(ctx, flags[0], debugLevelId, now, now.Add(duration))
+		if err != nil {
+			errDelete := c.DeleteToolingRecord(ctx, "DebugLevel", debugLevelId)
+			return nil, errors.Join(fmt.Errorf("c.takeOverTraceFlag: %w", err), errDelete)
+		}
+		return &DebugLogSession{TraceFlag: flag, reused: &flags[0]}, nil
+	}
+
+	flag := TraceFlag{
+		TracedEntityId: userId,
+		DebugLevelId:   debugLevelId,
+		LogType:        LogTypeUserDebug,
+		StartDate:      now.UTC().Format(sfDateTimeLayout),
+		ExpirationDate: now.Add(duration).UTC().Format(sfDateTimeLayout),
+	}
+	flag.Id, err = c.CreateToolingRecord(ctx, "TraceFlag", flag)
+	if err != nil {
+		errDelete := c.DeleteToolingRecord(ctx, "DebugLevel", debugLevelId)
+		return nil, errors.Join(fmt.Errorf("c.CreateToolingRecord: %w", err), errDelete)
+	}
+
+	return &DebugLogSession{TraceFlag: flag, created: true}, nil
+}
+
+// takeOverTraceFlag switches flag to the debug level and moves its expiration
+// to until if it's earlier. The start is moved to now if the flag would
+// otherwise be active for longer than Salesforce allows.
+func (c *Connection) takeOverTraceFlag(
+	ctx context.Context,
+	flag TraceFlag,
+	debugLevelId string,
+	now, until time.Time,
+) (TraceFlag, error) {
+	expiration, err := time.Parse(sfDateTimeLayout, flag.ExpirationDate)
+	if err != nil {
+		return TraceFlag{}, fmt.Errorf("time.Parse: %w", err)
+	}
+
+	update := map[string]string{"DebugLevelId": debugLevelId}
+	if expiration.Before(until) {
+		update["ExpirationDate"] = until.UTC().Format(sfDateTimeLayout)
+		start, err := time.Parse(sfDateTimeLayout, flag.StartDate)
+		if err != nil || until.Sub(start) > maxDebugLogPeriod {
+			update["StartDate"] = now.UTC().Format(sfDateTimeLayout)
+		}
+	}
+
+	if err := c.UpdateToolingRecord(ctx, "TraceFlag", flag.Id, update); err != nil {
+		return TraceFlag{}, fmt.Errorf("c.UpdateToolingRecord: %w", err)
+	}
+
+	flag.DebugLevelId = debugLevelId
+	if update["ExpirationDate"] != "" {
+		flag.ExpirationDate = update["ExpirationDate"]
+	}
+	if update["StartDate"] != "" {
+		flag.StartDate = update["StartDate"]
+	}
+
+	return flag, nil
+}
+
+// StopDebugLogs ends the session: a trace flag StartDebugLogs took over gets
+// its debug level and dates back, one it created is deleted. Either way the
+// session's debug level is deleted. A taken over flag that should have
+// expired in the meantime expires a minute after the session instead, as
+// Salesforce doesn't accept expiration dates in the past.
+func (c *Connection) StopDebugLogs(ctx context.Context, s *DebugLogSession) error {
+	if s == nil {
+		return nil
+	}
+
+	if s.reused != nil {
+		expiration := s.reused.ExpirationDate
+		if t, err := time.Parse(sfDateTimeLayout, expiration); err != nil || t.Before(time.Now().Add(time.Minute)) {
+			expiration = time.Now().Add(time.Minute).UTC().Format(sfDateTimeLayout)
+		}
+
+		update := map[string]string{"DebugLevelId": s.reused.DebugLevelId, "ExpirationDate": expiration}
+		if s.reused.StartDate != "" {
+			update["StartDate"] = s.reused.StartDate
+		}
+		if err := c.UpdateToolingRecord(ctx, "TraceFlag", s.TraceFlag.Id, update); err != nil {
+			return fmt.Errorf("c.UpdateToolingRecord: %w", err)
+		}
+	}
+
+	if s.created {
+		if err := c.DeleteToolingRecord(ctx, "TraceFlag", s.TraceFlag.Id); err != nil {
+			return fmt.Errorf("c.DeleteToolingRecord: %w", err)
+		}
+	}
+
+	if err := c.DeleteToolingRecord(ctx, "DebugLevel", s.TraceFlag.DebugLevelId); err != nil {
+		return fmt.Errorf("c.DeleteToolingRecord: %w", err)
+	}
+
+	return nil
+}
+
+// RequestApexLogs returns the debug logs of userId that started at or after
+// since, newest first.
+func (c *Connection) RequestApexLogs(ctx context.Context, userId string, since time.Time) ([]ApexLog, error) {
+	query := soql.Select(
+		"Id", "LogUserId", "LogLength", "Operation", "Request", "Status", "StartTime", "DurationMilliseconds",
+	).
+		From("ApexLog").
+		Where(soql.And(soql.Eq("LogUserId", userId), soql.Ge("StartTime", since))).
+		OrderByDesc("StartTime").
+		Tooling()
+
+	return queryAll[ApexLog](c, ctx, query.Path(c.ApiVersion))
+}
+
+// RequestApexLogBody downloads the contents of a debug log.
+func (c *Connection) RequestApexLogBody(ctx context.Context, logId string) (string, error) {
+	url := c.BaseUrl + "/services/data/v" + c.ApiVersion + "/tooling/sobjects/ApexLog/" + logId + "/Body"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	respBody, err := c.DoRequest(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	return string(respBody), nil
+}
+
+// requestExecuteAnonymousLog downloads the newest log of an executeAnonymous
+// request of the user.
+func (c *Connection) requestExecuteAnonymousLog(ctx context.Context, userId string, since time.Time) (string, error) {
+	logs, err := c.RequestApexLogs(ctx, userId, since)
+	if err != nil {
+		return "", fmt.Errorf("c.RequestApexLogs: %w", err)
+	}
+
+	for _, l := range logs {
+		if strings.Contains(l.Operation, "executeAnonymous") {
+			return c.RequestApexLogBody(ctx, l.Id)
+		}
+	}
+
+	return "", errors.New("no debug log of the execution found")
+}
+
+// CreateToolingRecord creates a record of a Tooling API object from the JSON
+// representation of record and returns its Id.
+func (c *Connection) CreateToolingRecord(ctx context.Context, object string, record any) (string, error) {
+	jsonBody, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	url := c.BaseUrl + "/services/data/v" + c.ApiVersion + "/tooling/sobjects/" + object + "/"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", fmt.Errorf("http.NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	respBody, err := c.DoRequest(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	var parsedResponse struct {
+		Id      string `json:"id"`
+		Success bool   `json:"success"`
+	}
+	if err := json.Unmarshal(respBody, &parsedResponse); err != nil {
+		return "", fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	if !parsedResponse.Success || parsedResponse.Id == "" {
+		return "", errors.New("failed to create " + object + ": " + string(respBody))
+	}
+
+	return parsedResponse.Id, nil
+}
+
+// UpdateToolingRecord sets the fields of a Tooling API record to the ones in
+// the JSON representation of fields.
+func (c *Connection) UpdateToolingRecord(ctx context.Context, object, id string, fields any) error {
+	jsonBody, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	url := c.BaseUrl + "/services/data/v" + c.ApiVersion + "/tooling/sobjects/" + object + "/" + id
+	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if _, err := c.DoRequest(ctx, req); err != nil {
+		return fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Connection) DeleteToolingRecord(ctx context.Context, object, id string) error {
+	url := c.BaseUrl + "/services/data/v" + c.ApiVersion + "/tooling/sobjects/" + object + "/" + id
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	if _, err := c.DoRequest(ctx, req); err != nil {
+		return fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	return nil
+}
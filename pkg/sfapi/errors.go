@@ -0,0 +1,131 @@
+package sfapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// APIError is returned for every response from Salesforce with a non-2xx status.
+// Use errors.As to branch on the returned error codes:
+//
+//	var apiErr *sfapi.APIError
+//	if errors.As(err, &apiErr) && apiErr.HasErrorCode("MALFORMED_QUERY") {
+//		...
+//	}
+type APIError struct {
+	StatusCode int
+	URL        string
+	Errors     []APIErrorItem
+	// Body is the raw response body, kept for responses that aren't
+	// in any of the known error formats.
+	Body []byte
+}
+
+type APIErrorItem struct {
+	ErrorCode string   `json:"errorCode"`
+	Message   string   `json:"message"`
+	Fields    []string `json:"fields,omitempty"`
+}
+
+func newAPIError(status int, url string, body []byte) *APIError {
+	return &APIError{
+		StatusCode: status,
+		URL:        url,
+		Errors:     parseErrorItems(body),
+		Body:       body,
+	}
+}
+
+func (e *APIError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("unexpected status code returned: ")
+	sb.WriteString(strconv.Itoa(e.StatusCode))
+	if e.URL != "" {
+		sb.WriteString(" from " + e.URL)
+	}
+
+	if len(e.Errors) == 0 {
+		sb.WriteString(", body: " + string(e.Body))
+		return sb.String()
+	}
+
+	for i, item := range e.Errors {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(item.ErrorCode + ": " + item.Message)
+		if len(item.Fields) > 0 {
+			sb.WriteString(" (fields: " + strings.Join(item.Fields, ", ") + ")")
+		}
+	}
+
+	return sb.String()
+}
+
+func (e *APIError) HasErrorCode(code string) bool {
+	return slices.ContainsFunc(e.Errors, func(item APIErrorItem) bool {
+		return item.ErrorCode == code
+	})
+}
+
+// parseErrorItems decodes the error formats Salesforce responds with: a list of
+// errors from REST and Tooling APIs, a single error object, an OAuth error and
+// a SOAP fault.
+func parseErrorItems(body []byte) []APIErrorItem {
+	if item, ok := parseSoapFault(body); ok {
+		return []APIErrorItem{item}
+	}
+
+	var items []APIErrorItem
+	if err := json.Unmarshal(body, &items); err == nil {
+		return items
+	}
+
+	var item struct {
+		APIErrorItem
+		OAuthError       string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &item); err != nil {
+		return nil
+	}
+
+	switch {
+	case item.ErrorCode != "":
+		return []APIErrorItem{item.APIErrorItem}
+	case item.OAuthError != "":
+		return []APIErrorItem{{ErrorCode: item.OAuthError, Message: item.ErrorDescription}}
+	}
+
+	return nil
+}
+
+// parseSoapFault decodes a SOAP fault, e.g. of the SOAP Apex API. The
+// namespace prefix of the fault code is dropped, so sf:INVALID_SESSION_ID
+// becomes INVALID_SESSION_ID like in the other formats.
+func parseSoapFault(body []byte) (APIErrorItem, bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		return APIErrorItem{}, false
+	}
+
+	var envelope struct {
+		FaultCode   string `xml:"Body>Fault>faultcode"`
+		FaultString string `xml:"Body>Fault>faultstring"`
+	}
+	if err := xml.Unmarshal(body, &envelope); err != nil || envelope.FaultCode == "" {
+		return APIErrorItem{}, false
+	}
+
+	code := envelope.FaultCode
+	if _, name, ok := strings.Cut(code, ":"); ok {
+		code = name
+	}
+
+	return APIErrorItem{ErrorCode: code, Message: envelope.FaultString}, true
+}
@@ -0,0 +1,52 @@
+package sfapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantItems []APIErrorItem
+		wantMsg   string
+	}{
+		{
+			name: "REST error list",
+			body: `[{"message":"No such column 'Foo'","errorCode":"INVALID_FIELD","fields":["Foo"]}]`,
+			wantItems: []APIErrorItem{
+				{ErrorCode: "INVALID_FIELD", Message: "No such column 'Foo'", Fields: []string{"Foo"}},
+			},
+			wantMsg: "unexpected status code returned: 400 from https://x/q: INVALID_FIELD: No such column 'Foo' (fields: Foo)",
+		},
+		{
+			name:      "OAuth error",
+			body:      `{"error":"invalid_grant","error_description":"authentication failure"}`,
+			wantItems: []APIErrorItem{{ErrorCode: "invalid_grant", Message: "authentication failure"}},
+			wantMsg:   "unexpected status code returned: 400 from https://x/q: invalid_grant: authentication failure",
+		},
+		{
+			name: "SOAP fault",
+			body: `<?xml version="1.0" encoding="UTF-8"?><soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:sf="urn:fault.partner.soap.sforce.com"><soapenv:Body><soapenv:Fault><faultcode>sf:INVALID_SESSION_ID</faultcode><faultstring>INVALID_SESSION_ID: Invalid Session ID found in SessionHeader</faultstring></soapenv:Fault></soapenv:Body></soapenv:Envelope>`,
+			wantItems: []APIErrorItem{
+				{ErrorCode: "INVALID_SESSION_ID", Message: "INVALID_SESSION_ID: Invalid Session ID found in SessionHeader"},
+			},
+			wantMsg: "unexpected status code returned: 400 from https://x/q: INVALID_SESSION_ID: INVALID_SESSION_ID: Invalid Session ID found in SessionHeader",
+		},
+		{
+			name:    "Unknown format",
+			body:    "Bad Request",
+			wantMsg: "unexpected status code returned: 400 from https://x/q, body: Bad Request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newAPIError(400, "https://x/q", []byte(tt.body))
+			assert.Equal(t, tt.wantItems, err.Errors)
+			assert.Equal(t, tt.wantMsg, err.Error())
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/achere/g-force/pkg/sfapi/soql"
+)
+
+var loginHosts = map[string]bool{
+	"login.salesforce.com": true,
+	"test.salesforce.com":  true,
+}
+
+type Identity struct {
+	Id             string `json:"id"`
+	UserId         string `json:"user_id"`
+	OrganizationId string `json:"organization_id"`
+	Username       string `json:"username"`
+	DisplayName    string `json:"display_name"`
+	Email          string `json:"email"`
+	UserType       string `json:"user_type"`
+	IsSandbox      bool   `json:"-"`
+}
+
+// Identify requests the identity of the user the access token was issued for
+// and whether the org is a sandbox, and stores the org and user Ids on the Connection.
+// Requests running concurrently with it should use the returned Identity
+// rather than read those fields.
+func (c *Connection) Identify(ctx context.Context) (Identity, error) {
+	token, err := c.getAccessToken(ctx)
+	if err != nil {
+		return Identity{}, fmt.Errorf("c.getAccessToken: %w", err)
+	}
+
+	identityUrl := token.IdentityUrl
+	if identityUrl == "" {
+		identityUrl = c.BaseUrl + "/services/oauth2/userinfo"
+	}
+
+	req, err := http.NewRequest(http.MethodGet, identityUrl, nil)
+	if err != nil {
+		return Identity{}, fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	respBody, err := c.DoRequest(ctx, req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	var identity Identity
+	if err := json.Unmarshal(respBody, &identity); err != nil {
+		return Identity{}, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	if identity.UserId == "" || identity.OrganizationId == "" {
+		return Identity{}, errors.New("identity response doesn't contain user and organization Ids")
+	}
+
+	identity.IsSandbox, err = c.requestIsSandbox(ctx)
+	if err != nil {
+		return Identity{}, fmt.Errorf("c.requestIsSandbox: %w", err)
+	}
+
+	c.mu.Lock()
+	c.OrgId = identity.OrganizationId
+	c.UserId = identity.UserId
+	c.IsSandbox = identity.IsSandbox
+	c.mu.Unlock()
+
+	return identity, nil
+}
+
+// runningUserId returns the Id of the user the Connection runs as, which is
+// identified first if the Connection doesn't know it yet.
+func (c *Connection) runningUserId(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	userId := c.UserId
+	c.mu.Unlock()
+	if userId != "" {
+		return userId, nil
+	}
+
+	identity, err := c.Identify(ctx)
+	if err != nil {
+		return "", fmt.Errorf("c.Identify: %w", err)
+	}
+
+	return identity.UserId, nil
+}
+
+func (c *Connection) requestIsSandbox(ctx context.Context) (bool, error) {
+	query := soql.Select("IsSandbox").From("Organization")
+	req, err := http.NewRequest(http.MethodGet, c.BaseUrl+query.Path(c.ApiVersion), nil)
+	if err != nil {
+		return false, fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	respBody, err := c.DoRequest(ctx, req)
+	if err != nil {
+		return false, fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	var parsedResponse struct {
+		Records []struct {
+			IsSandbox bool `json:"IsSandbox"`
+		} `json:"records"`
+	}
+	if err := json.Unmarshal(respBody, &parsedResponse); err != nil {
+		return false, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	if len(parsedResponse.Records) == 0 {
+		return false, errors.New("no Organization record returned")
+	}
+
+	return parsedResponse.Records[0].IsSandbox, nil
+}
+
+// OrgNamespace returns the namespace prefix of the org, empty if it has none.
+// Apex of a namespaced dev or scratch org carries the prefix although
+// package.xml lists it without. It's requested once per Connection.
+func (c *Connection) OrgNamespace(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	ns := c.orgNamespace
+	c.mu.Unlock()
+	if ns != nil {
+		return *ns, nil
+	}
+
+	query := soql.Select("NamespacePrefix").From("Organization")
+	records, err := queryAll[struct {
+		NamespacePrefix string `json:"NamespacePrefix"`
+	}](c, ctx, query.Path(c.ApiVersion))
+	if err != nil {
+		return "", err
+	}
+
+	if len(records) == 0 {
+		return "", errors.New("no Organization record returned")
+	}
+
+	c.mu.Lock()
+	c.orgNamespace = &records[0].NamespacePrefix
+	c.mu.Unlock()
+
+	return records[0].NamespacePrefix, nil
+}
+
+// routeToInstance points requests built against a generic login host to the
+// instance the access token was issued for.
+func (c *Connection) routeToInstance(req *http.Request, token *Token) {
+	if token == nil || token.InstanceUrl == "" {
+		return
+	}
+
+	base, err := url.Parse(c.BaseUrl)
+	if err != nil || !loginHosts[strings.ToLower(base.Hostname())] || req.URL.Host != base.Host {
+		return
+	}
+
+	instance, err := url.Parse(token.InstanceUrl)
+	if err != nil || instance.Host == "" {
+		return
+	}
+
+	req.URL.Scheme = instance.Scheme
+	req.URL.Host = instance.Host
+	req.Host = ""
+}
@@ -0,0 +1,88 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentify(t *testing.T) {
+	var mockServer *httptest.Server
+	mockServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/services/oauth2/token":
+			json.NewEncoder(w).Encode(TokenResponse{
+				AccessToken: "token",
+				InstanceUrl: mockServer.URL,
+				Id:          mockServer.URL + "/id/00D000000000001/005000000000001",
+				IssuedAt:    "1700000000000",
+			})
+		case "/id/00D000000000001/005000000000001":
+			w.Write([]byte(`{"user_id":"005000000000001","organization_id":"00D000000000001","username":"user@example.com"}`))
+		case "/services/data/v60.0/query/":
+			assert.Equal(t, "SELECT IsSandbox FROM Organization", r.URL.Query().Get("q"))
+			w.Write([]byte(`{"totalSize":1,"done":true,"records":[{"IsSandbox":true}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	c := &Connection{
+		BaseUrl:    mockServer.URL,
+		ApiVersion: "60.0",
+		HttpClient: mockServer.Client(),
+	}
+
+	identity, err := c.Identify(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", identity.Username)
+	assert.Equal(t, "00D000000000001", c.OrgId)
+	assert.Equal(t, "005000000000001", c.UserId)
+	assert.True(t, c.IsSandbox)
+	assert.Equal(t, int64(1700000000000), c.token.IssuedAt.UnixMilli())
+}
+
+func TestRouteToInstance(t *testing.T) {
+	tests := []struct {
+		name    string
+		baseUrl string
+		reqUrl  string
+		wantUrl string
+	}{
+		{
+			name:    "Login host",
+			baseUrl: "https://login.salesforce.com",
+			reqUrl:  "https://login.salesforce.com/services/data/v60.0/query/?q=x",
+			wantUrl: "https://org.my.salesforce.com/services/data/v60.0/query/?q=x",
+		},
+		{
+			name:    "Sandbox login host",
+			baseUrl: "https://test.salesforce.com",
+			reqUrl:  "https://test.salesforce.com/services/data/v60.0/",
+			wantUrl: "https://org.my.salesforce.com/services/data/v60.0/",
+		},
+		{
+			name:    "My Domain",
+			baseUrl: "https://other.my.salesforce.com",
+			reqUrl:  "https://other.my.salesforce.com/services/data/v60.0/",
+			wantUrl: "https://other.my.salesforce.com/services/data/v60.0/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Connection{BaseUrl: tt.baseUrl}
+			token := &Token{AccessToken: "token", InstanceUrl: "https://org.my.salesforce.com"}
+
+			req, _ := http.NewRequest(http.MethodGet, tt.reqUrl, nil)
+			c.routeToInstance(req, token)
+			assert.Equal(t, tt.wantUrl, req.URL.String())
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package sfapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AudienceProduction = "https://login.salesforce.com"
+	AudienceSandbox    = "https://test.salesforce.com"
+)
+
+const jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// LoadPrivateKey reads a PEM encoded RSA private key from the file at path.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	return ParsePrivateKey(data)
+}
+
+// ParsePrivateKey decodes a PEM encoded RSA private key in either PKCS#1 or PKCS#8 form.
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParsePKCS8PrivateKey: %w", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+// JwtBearerSource obtains tokens with the OAuth 2.0 JWT Bearer Flow by signing
+// an assertion for Username with PrivateKey. Audience defaults to the login
+// host matching BaseUrl.
+type JwtBearerSource struct {
+	BaseUrl    string
+	ClientId   string
+	Username   string
+	PrivateKey *rsa.PrivateKey
+	Audience   string
+	HttpClient *http.Client
+}
+
+func (s *JwtBearerSource) Token(ctx context.Context) (*Token, error) {
+	audience := s.Audience
+	if audience == "" {
+		audience = defaultAudience(s.BaseUrl)
+	}
+
+	assertion, err := signJwtAssertion(s.PrivateKey, s.ClientId, s.Username, audience, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("signJwtAssertion: %w", err)
+	}
+
+	formData := url.Values{}
+	formData.Set("grant_type", jwtBearerGrantType)
+	formData.Set("assertion", assertion)
+
+	return requestToken(ctx, s.HttpClient, s.BaseUrl, formData)
+}
+
+func signJwtAssertion(key *rsa.PrivateKey, clientId, username, audience string, now time.Time) (string, error) {
+	if key == nil {
+		return "", errors.New("private key is required")
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	claims, err := json.Marshal(struct {
+		Iss string `json:"iss"`
+		Sub string `json:"sub"`
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+	}{
+		Iss: clientId,
+		Sub: username,
+		Aud: audience,
+		Exp: now.Add(3 * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("rsa.SignPKCS1v15: %w", err)
+	}
+
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+func defaultAudience(baseUrl string) string {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return AudienceProduction
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "test.salesforce.com" || strings.HasSuffix(host, ".sandbox.my.salesforce.com") {
+		return AudienceSandbox
+	}
+
+	return AudienceProduction
+}
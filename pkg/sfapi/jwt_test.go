@@ -0,0 +1,109 @@
+package sfapi
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJwtBearerFlow(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/oauth2/token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		r.ParseForm()
+		assert.Equal(t, jwtBearerGrantType, r.PostForm.Get("grant_type"))
+
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature))
+
+		rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		var claims map[string]any
+		require.NoError(t, json.Unmarshal(rawClaims, &claims))
+		assert.Equal(t, "client", claims["iss"])
+		assert.Equal(t, "user@example.com", claims["sub"])
+		assert.Equal(t, AudienceSandbox, claims["aud"])
+		assert.Greater(t, claims["exp"], float64(time.Now().Unix()))
+
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "jwt-token"})
+	}))
+	defer mockServer.Close()
+
+	c := &Connection{
+		BaseUrl:    mockServer.URL,
+		ClientId:   "client",
+		Username:   "user@example.com",
+		PrivateKey: key,
+		Audience:   AudienceSandbox,
+		HttpClient: mockServer.Client(),
+	}
+
+	token, err := c.getAccessToken(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "jwt-token", token.AccessToken)
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{
+			name: "PKCS1",
+			data: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+		{
+			name: "PKCS8",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		},
+		{
+			name:    "Not PEM",
+			data:    []byte("not a key"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParsePrivateKey(tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, key.Equal(parsed))
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const limitInfoHeader = "Sforce-Limit-Info"
+
+const (
+	LimitDailyApiRequests         = "DailyApiRequests"
+	LimitDailyAsyncApexExecutions = "DailyAsyncApexExecutions"
+	LimitDailyBulkApiBatches      = "DailyBulkApiBatches"
+	LimitDataStorageMB            = "DataStorageMB"
+	LimitFileStorageMB            = "FileStorageMB"
+)
+
+// ErrApiUsageLimit is returned by DoRequest without calling Salesforce once
+// the share of the daily API requests used reaches Connection.ApiUsageLimit.
+// Requests of the limits resource are still sent, so RequestLimits can refresh
+// the usage, see also ResetApiUsage.
+var ErrApiUsageLimit = errors.New("daily API usage limit reached")
+
+// ApiUsage is the consumption of daily API requests reported by Salesforce
+// in the Sforce-Limit-Info header of the latest response.
+type ApiUsage struct {
+	Used int
+	Max  int
+}
+
+func (u ApiUsage) Share() float64 {
+	if u.Max == 0 {
+		return 0
+	}
+
+	return float64(u.Used) / float64(u.Max)
+}
+
+type Limit struct {
+	Max       int `json:"Max"`
+	Remaining int `json:"Remaining"`
+}
+
+// Limits maps the names of org limits, e.g. LimitDailyApiRequests, to their values.
+type Limits map[string]Limit
+
+// ApiUsage returns the latest known API usage and false if no response
+// with the Sforce-Limit-Info header has been received yet.
+func (c *Connection) ApiUsage() (ApiUsage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.apiUsage, c.apiUsage.Max > 0
+}
+
+// ResetApiUsage forgets the latest known API usage, e.g. to lift the block of
+// ApiUsageLimit after the daily allowance has been raised. The usage is known
+// again with the next response and a warning is reported again if needed.
+func (c *Connection) ResetApiUsage() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.apiUsage = ApiUsage{}
+	c.apiUsageWarned = false
+}
+
+// RequestLimits requests the current values of all org limits. It's exempt
+// from ApiUsageLimit and updates the usage ApiUsage returns.
+func (c *Connection) RequestLimits(ctx context.Context) (Limits, error) {
+	req, err := http.NewRequest(http.MethodGet, c.BaseUrl+"/services/data/v"+c.ApiVersion+"/limits", nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	respBody, err := c.DoRequest(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	var limits Limits
+	if err := json.Unmarshal(respBody, &limits); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return limits, nil
+}
+
+func (c *Connection) checkApiUsage(req *http.Request) error {
+	if c.ApiUsageLimit <= 0 || isLimitsRequest(req) {
+		return nil
+	}
+
+	usage, ok := c.ApiUsage()
+	if !ok || usage.Share() < c.ApiUsageLimit {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %d of %d used", ErrApiUsageLimit, usage.Used, usage.Max)
+}
+
+func isLimitsRequest(req *http.Request) bool {
+	return strings.HasSuffix(strings.TrimSuffix(req.URL.Path, "/"), "/limits")
+}
+
+func (c *Connection) recordApiUsage(header http.Header) {
+	usage, ok := parseLimitInfo(header.Get(limitInfoHeader))
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	c.apiUsage = usage
+	warn := c.OnApiUsageWarning != nil && c.ApiUsageWarning > 0 &&
+		!c.apiUsageWarned && usage.Share() >= c.ApiUsageWarning
+	if warn {
+		c.apiUsageWarned = true
+	}
+	c.mu.Unlock()
+
+	if warn {
+		c.OnApiUsageWarning(usage)
+	}
+}
+
+// parseLimitInfo parses the header value in the form "api-usage=25/5000".
+func parseLimitInfo(value string) (ApiUsage, bool) {
+	for _, part := range strings.Split(value, ",") {
+		name, usage, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || name != "api-usage" {
+			continue
+		}
+
+		usedStr, maxStr, ok := strings.Cut(usage, "/")
+		if !ok {
+			return ApiUsage{}, false
+		}
+
+		used, errUsed := strconv.Atoi(usedStr)
+		maxUsage, errMax := strconv.Atoi(maxStr)
+		if errUsed != nil || errMax != nil {
+			return ApiUsage{}, false
+		}
+
+		return ApiUsage{Used: used, Max: maxUsage}, true
+	}
+
+	return ApiUsage{}, false
+}
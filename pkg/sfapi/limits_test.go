@@ -0,0 +1,109 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiUsage(t *testing.T) {
+	var used atomic.Int32
+	used.Store(4600)
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/services/oauth2/token":
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "token"})
+		case "/services/data/v60.0/limits":
+			w.Header().Set(limitInfoHeader, fmt.Sprintf("api-usage=%d/5000", used.Load()))
+			w.Write([]byte(`{"DailyApiRequests":{"Max":5000,"Remaining":400},"DataStorageMB":{"Max":200,"Remaining":180}}`))
+		case "/services/data/v60.0/sobjects":
+			w.Header().Set(limitInfoHeader, fmt.Sprintf("api-usage=%d/5000", used.Load()))
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	var warnings []ApiUsage
+	c := &Connection{
+		BaseUrl:           mockServer.URL,
+		ApiVersion:        "60.0",
+		HttpClient:        mockServer.Client(),
+		ApiUsageLimit:     0.95,
+		ApiUsageWarning:   0.9,
+		OnApiUsageWarning: func(u ApiUsage) { warnings = append(warnings, u) },
+	}
+
+	ctx := context.Background()
+	_, ok := c.ApiUsage()
+	assert.False(t, ok)
+
+	limits, err := c.RequestLimits(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Max: 5000, Remaining: 400}, limits[LimitDailyApiRequests])
+
+	usage, ok := c.ApiUsage()
+	assert.True(t, ok)
+	assert.Equal(t, ApiUsage{Used: 4600, Max: 5000}, usage)
+
+	_, err = c.RequestLimits(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []ApiUsage{{Used: 4600, Max: 5000}}, warnings, "warning must be reported once")
+
+	c.ApiUsageLimit = 0.9
+	requestSObjects := func() error {
+		req, err := http.NewRequest(http.MethodGet, mockServer.URL+"/services/data/v60.0/sobjects", nil)
+		require.NoError(t, err)
+		_, err = c.DoRequest(ctx, req)
+		return err
+	}
+	assert.True(t, errors.Is(requestSObjects(), ErrApiUsageLimit))
+
+	t.Run("Refreshed by RequestLimits", func(t *testing.T) {
+		used.Store(100)
+		_, err := c.RequestLimits(ctx)
+		require.NoError(t, err, "limits must be requested despite the block")
+		assert.NoError(t, requestSObjects())
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		used.Store(4600)
+		require.NoError(t, requestSObjects())
+		require.True(t, errors.Is(requestSObjects(), ErrApiUsageLimit))
+
+		c.ResetApiUsage()
+		_, ok := c.ApiUsage()
+		assert.False(t, ok)
+		assert.NoError(t, requestSObjects())
+	})
+}
+
+func TestParseLimitInfo(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   ApiUsage
+		wantOk bool
+	}{
+		{value: "api-usage=25/5000", want: ApiUsage{Used: 25, Max: 5000}, wantOk: true},
+		{value: "per-app-api-usage=1/100, api-usage=30/15000", want: ApiUsage{Used: 30, Max: 15000}, wantOk: true},
+		{value: "api-usage=x/5000"},
+		{value: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseLimitInfo(tt.value)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package sfapi
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+var sensitiveParams = []string{
+	"access_token",
+	"refresh_token",
+	"client_secret",
+	"assertion",
+	"password",
+	"code",
+	"sid",
+}
+
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// RoundTripFunc performs a single HTTP exchange.
+type RoundTripFunc func(*http.Request) (*http.Response, error)
+
+// Middleware wraps every HTTP exchange DoRequest makes with Salesforce,
+// including retries. Middleware is applied in order, so the first one sees
+// the request first and the response last. It receives the request with
+// the access token already set.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// RequestInfo describes a completed HTTP exchange for hooks. URL and Header
+// have secrets and tokens redacted, so it's safe to log.
+type RequestInfo struct {
+	Method     string
+	URL        string
+	Header     http.Header
+	StatusCode int
+	Duration   time.Duration
+	ApiUsage   ApiUsage
+	// Err is set if no response was received, error statuses are only
+	// reported in StatusCode. Its message is redacted with RedactError, the
+	// error it wraps isn't.
+	Err error
+}
+
+// WithHeader returns a Middleware that sets a header on every request.
+func WithHeader(name, value string) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(req *http.Request) (*http.Response, error) {
+			req.Header.Set(name, value)
+			return next(req)
+		}
+	}
+}
+
+// WithClientName returns a Middleware that sets the client name reported
+// in the Sforce-Call-Options header, which shows up in API usage metrics.
+func WithClientName(client string) Middleware {
+	return WithHeader("Sforce-Call-Options", "client="+client)
+}
+
+// LogHook returns a hook for Connection.AfterRequest that logs every exchange.
+func LogHook(logger *slog.Logger) func(RequestInfo) {
+	return func(info RequestInfo) {
+		attrs := []slog.Attr{
+			slog.String("method", info.Method),
+			slog.String("url", info.URL),
+			slog.Int("status", info.StatusCode),
+			slog.Duration("duration", info.Duration),
+		}
+		if info.ApiUsage.Max > 0 {
+			attrs = append(attrs, slog.Int("apiUsed", info.ApiUsage.Used), slog.Int("apiMax", info.ApiUsage.Max))
+		}
+
+		level := slog.LevelInfo
+		if info.Err != nil {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", info.Err.Error()))
+		}
+
+		logger.LogAttrs(context.Background(), level, "salesforce request", attrs...)
+	}
+}
+
+// RedactUrl replaces the values of query parameters that carry secrets.
+func RedactUrl(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+
+	redactedUrl := *u
+	redactedUrl.User = nil
+
+	query := u.Query()
+	changed := false
+	for key := range query {
+		if isSensitiveParam(key) {
+			query.Set(key, redacted)
+			changed = true
+		}
+	}
+	if changed {
+		redactedUrl.RawQuery = query.Encode()
+	}
+
+	return redactedUrl.String()
+}
+
+// RedactError returns the message of err with the URL of a transport error,
+// which Go includes in full, passed through RedactUrl.
+func RedactError(err error) string {
+	msg := err.Error()
+
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return msg
+	}
+
+	u, parseErr := url.Parse(urlErr.URL)
+	if parseErr != nil {
+		return strings.ReplaceAll(msg, urlErr.URL, redacted)
+	}
+
+	return strings.ReplaceAll(msg, urlErr.URL, RedactUrl(u))
+}
+
+// redactedError is an error with the message from RedactError. It still
+// unwraps to the original, e.g. for errors.Is(err, context.Canceled).
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// RedactHeader returns a copy of header with credentials replaced.
+func RedactHeader(header http.Header) http.Header {
+	res := header.Clone()
+	for _, name := range sensitiveHeaders {
+		if res.Get(name) != "" {
+			res.Set(name, redacted)
+		}
+	}
+
+	return res
+}
+
+func isSensitiveParam(name string) bool {
+	return slices.Contains(sensitiveParams, strings.ToLower(name))
+}
+
+func (c *Connection) roundTrip() RoundTripFunc {
+	rt := c.httpClient().Do
+	for i := len(c.Middleware) - 1; i >= 0; i-- {
+		rt = c.Middleware[i](rt)
+	}
+
+	return rt
+}
+
+func (c *Connection) afterRequest(req *http.Request, resp *http.Response, start time.Time, err error) {
+	if len(c.AfterRequest) == 0 {
+		return
+	}
+
+	usage, _ := c.ApiUsage()
+	info := RequestInfo{
+		Method:   req.Method,
+		URL:      RedactUrl(req.URL),
+		Header:   RedactHeader(req.Header),
+		Duration: time.Since(start),
+		ApiUsage: usage,
+	}
+	if err != nil {
+		info.Err = &redactedError{msg: RedactError(err), err: err}
+	}
+	if resp != nil {
+		info.StatusCode = resp.StatusCode
+	}
+
+	for _, hook := range c.AfterRequest {
+		hook(info)
+	}
+}
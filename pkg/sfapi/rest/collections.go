@@ -141,7 +141,7 @@ func CollectionsCreate(
 				mu.Lock()
 				result = multierror.Append(
 					result,
-					fmt.Errorf("batch from %v to %v failed with err: %v", from, to, err),
+					fmt.Errorf("batch from %v to %v failed with err: %w", from, to, err),
 				)
 				mu.Unlock()
 				return nil
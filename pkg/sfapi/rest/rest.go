@@ -5,16 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
 
 	"github.com/achere/g-force/pkg/sfapi"
-	multierror "github.com/hashicorp/go-multierror"
+	"github.com/achere/g-force/pkg/sfapi/soql"
 )
 
 type QueryResponseSuccess struct {
-	TotalSize int      `json:"totalSize"`
-	Done      bool     `json:"done"`
-	Records   []Record `json:"records"`
+	TotalSize      int      `json:"totalSize"`
+	Done           bool     `json:"done"`
+	NextRecordsUrl string   `json:"nextRecordsUrl,omitempty"`
+	Records        []Record `json:"records"`
 }
 
 type Record struct {
@@ -27,6 +27,8 @@ type Record_Attributes struct {
 	URL  string `json:"url"`
 }
 
+// QueryResponseError is an element of the error response body. Errors returned
+// by Query are *sfapi.APIError that contain all of the elements.
 type QueryResponseError struct {
 	Message   string `json:"message"`
 	ErrorCode string `json:"errorCode"`
@@ -75,37 +77,46 @@ func (r *Record) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Query runs a SOQL query with the REST API and returns all matching records,
+// following nextRecordsUrl until the last page. Build queries with values
+// in them with package soql.
 func Query(c *sfapi.Connection, ctx context.Context, query string) ([]Record, error) {
-	qUrl := c.BaseUrl + "/services/data/v" + c.ApiVersion + "/query/?q=" + url.QueryEscape(query)
+	qUrl := c.BaseUrl + soql.REST.Path(c.ApiVersion, query)
 
-	req, err := http.NewRequest(http.MethodGet, qUrl, nil)
-	if err != nil {
-		return nil, fmt.Errorf("http.NewRequest: %w", err)
-	}
+	var records []Record
+	for {
+		req, err := http.NewRequest(http.MethodGet, qUrl, nil)
+		if err != nil {
+			return nil, fmt.Errorf("http.NewRequest: %w", err)
+		}
 
-	req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.DoRequest(ctx, req)
-	if err != nil {
-		if resp == nil {
+		resp, err := c.DoRequest(ctx, req)
+		if err != nil {
 			return nil, fmt.Errorf("connection.DoRequest: %w", err)
 		}
 
-		var bodyErr []QueryResponseError
-		if errFail := json.Unmarshal(resp, &bodyErr); errFail != nil {
-			var multiErr *multierror.Error
-			multiErr = multierror.Append(multiErr, err, fmt.Errorf("json.Unmarshal: %w", errFail))
-
-			return nil, multiErr.ErrorOrNil()
+		var bodySucc QueryResponseSuccess
+		if errSucc := json.Unmarshal(resp, &bodySucc); errSucc != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %w", errSucc)
 		}
 
-		return nil, fmt.Errorf("connection.DoRequest: %s:%s", bodyErr[0].ErrorCode, bodyErr[0].Message)
+		records = append(records, bodySucc.Records...)
+		if bodySucc.Done || bodySucc.NextRecordsUrl == "" {
+			return records, nil
+		}
+		qUrl = c.BaseUrl + bodySucc.NextRecordsUrl
 	}
+}
 
-	var bodySucc QueryResponseSuccess
-	if errSucc := json.Unmarshal(resp, &bodySucc); errSucc != nil {
-		return nil, fmt.Errorf("json.Unmarshal: %w", errSucc)
+// ToolingQuery runs a SOQL query with the Tooling API and returns all matching
+// records, for objects that have no struct to decode them into.
+func ToolingQuery(c *sfapi.Connection, ctx context.Context, query string) ([]Record, error) {
+	records, err := sfapi.ToolingQuery[Record](c, ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("sfapi.ToolingQuery: %w", err)
 	}
 
-	return bodySucc.Records, nil
+	return records, nil
 }
@@ -53,6 +53,11 @@ func TestQuery(t *testing.T) {
 			verify: func(a any, err error) {
 				assert.Error(t, err)
 				assert.ErrorContains(t, err, errorCode)
+
+				var apiErr *sfapi.APIError
+				assert.ErrorAs(t, err, &apiErr)
+				assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+				assert.True(t, apiErr.HasErrorCode(errorCode))
 			},
 		},
 	}
@@ -0,0 +1,123 @@
+package sfapi
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+type noRetryKey struct{}
+
+// RetryPolicy controls how DoRequest retries requests that failed with
+// a transient error: a network failure, one of RetryableStatusCodes or
+// a response carrying one of RetryableErrorCodes. A nil policy makes
+// a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	MaxAttempts int
+	// BaseDelay is doubled after each attempt up to MaxDelay, and a random
+	// jitter of up to half of it is subtracted.
+	BaseDelay            time.Duration
+	MaxDelay             time.Duration
+	RetryableStatusCodes []int
+	RetryableErrorCodes  []string
+	// RetryNonIdempotent allows retrying methods like POST and PATCH that
+	// might have taken effect even though the response was an error.
+	RetryNonIdempotent bool
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:          4,
+		BaseDelay:            500 * time.Millisecond,
+		MaxDelay:             10 * time.Second,
+		RetryableStatusCodes: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		RetryableErrorCodes:  []string{"UNKNOWN_EXCEPTION", "SERVER_UNAVAILABLE"},
+	}
+}
+
+// WithoutRetries returns a context that disables the RetryPolicy for requests
+// made with it, e.g. for GET requests that aren't safe to repeat.
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetryKey{}, true)
+}
+
+func (p *RetryPolicy) shouldRetry(
+	ctx context.Context,
+	req *http.Request,
+	attempt, status int,
+	body []byte,
+	err error,
+) bool {
+	if p == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+	if noRetry, _ := ctx.Value(noRetryKey{}).(bool); noRetry {
+		return false
+	}
+	if !p.RetryNonIdempotent && !isIdempotent(req.Method) {
+		return false
+	}
+
+	if status == 0 {
+		var netErr net.Error
+		return errors.As(err, &netErr)
+	}
+
+	if slices.Contains(p.RetryableStatusCodes, status) {
+		return true
+	}
+
+	for _, item := range parseErrorItems(body) {
+		if slices.Contains(p.RetryableErrorCodes, item.ErrorCode) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wait sleeps before the next attempt and fails right away if ctx would be
+// done before the delay is over.
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) error {
+	delay := p.backoff(attempt)
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return context.DeadlineExceeded
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay - rand.N(delay/2+1)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
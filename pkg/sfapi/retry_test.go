@@ -0,0 +1,150 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoRequestRetries(t *testing.T) {
+	policy := &RetryPolicy{
+		MaxAttempts:          3,
+		BaseDelay:            time.Millisecond,
+		MaxDelay:             5 * time.Millisecond,
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		RetryableErrorCodes:  []string{"UNKNOWN_EXCEPTION"},
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		policy       *RetryPolicy
+		failures     int
+		failStatus   int
+		failBody     string
+		ctxTimeout   time.Duration
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{
+			name:         "Recovers from 503",
+			method:       http.MethodGet,
+			policy:       policy,
+			failures:     2,
+			failStatus:   http.StatusServiceUnavailable,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Recovers from UNKNOWN_EXCEPTION",
+			method:       http.MethodGet,
+			policy:       policy,
+			failures:     1,
+			failStatus:   http.StatusInternalServerError,
+			failBody:     `[{"message":"An unexpected error occurred","errorCode":"UNKNOWN_EXCEPTION"}]`,
+			wantAttempts: 2,
+		},
+		{
+			name:         "Gives up after max attempts",
+			method:       http.MethodGet,
+			policy:       policy,
+			failures:     5,
+			failStatus:   http.StatusServiceUnavailable,
+			wantErr:      true,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Doesn't retry non-retryable error",
+			method:       http.MethodGet,
+			policy:       policy,
+			failures:     1,
+			failStatus:   http.StatusBadRequest,
+			failBody:     `[{"message":"unexpected token","errorCode":"MALFORMED_QUERY"}]`,
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Doesn't retry POST",
+			method:       http.MethodPost,
+			policy:       policy,
+			failures:     1,
+			failStatus:   http.StatusServiceUnavailable,
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Doesn't retry without policy",
+			method:       http.MethodGet,
+			failures:     1,
+			failStatus:   http.StatusServiceUnavailable,
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+		{
+			name:   "Respects context deadline",
+			method: http.MethodGet,
+			policy: &RetryPolicy{
+				MaxAttempts:          3,
+				BaseDelay:            time.Second,
+				RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+			},
+			failures:     1,
+			failStatus:   http.StatusServiceUnavailable,
+			ctxTimeout:   100 * time.Millisecond,
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var attempts atomic.Int32
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/services/oauth2/token" {
+					json.NewEncoder(w).Encode(TokenResponse{AccessToken: "token"})
+					return
+				}
+
+				if int(attempts.Add(1)) <= tt.failures {
+					w.WriteHeader(tt.failStatus)
+					w.Write([]byte(tt.failBody))
+					return
+				}
+				w.Write([]byte("{}"))
+			}))
+			defer mockServer.Close()
+
+			c := &Connection{
+				BaseUrl:     mockServer.URL,
+				ApiVersion:  "60.0",
+				HttpClient:  mockServer.Client(),
+				RetryPolicy: tt.policy,
+			}
+
+			ctx := context.Background()
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+
+			req, _ := http.NewRequest(tt.method, mockServer.URL+"/services/data/v60.0/", strings.NewReader("{}"))
+			_, err := c.DoRequest(ctx, req)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantAttempts, attempts.Load())
+		})
+	}
+}
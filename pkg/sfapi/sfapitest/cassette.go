@@ -0,0 +1,353 @@
+package sfapitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/achere/g-force/pkg/sfapi"
+)
+
+const redacted = "REDACTED"
+
+// Mode selects whether a Recorder captures real traffic or replays a cassette.
+type Mode int
+
+const (
+	ModeReplay Mode = iota
+	ModeRecord
+)
+
+// secretFields are scrubbed from form and JSON bodies before they're stored.
+var secretFields = []string{
+	"access_token",
+	"refresh_token",
+	"client_secret",
+	"assertion",
+	"password",
+	"signature",
+	"code",
+}
+
+// secretElements match the XML elements whose content is scrubbed from SOAP
+// bodies: the session header of requests and the session ids of responses.
+var secretElements = []*regexp.Regexp{
+	regexp.MustCompile(`(?s)(<(?:[\w-]+:)?SessionHeader\b[^>]*>).*?(</(?:[\w-]+:)?SessionHeader>)`),
+	regexp.MustCompile(`(?s)(<(?:[\w-]+:)?sessionId\b[^>]*>).*?(</(?:[\w-]+:)?sessionId>)`),
+}
+
+// Cassette is the stored form of recorded traffic.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Query holds the decoded query parameters, including the SOQL in q.
+	Query url.Values `json:"query,omitempty"`
+	Body  string     `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Recorder is an http.RoundTripper that records traffic to a cassette file or
+// replays it. Plug it into a Connection with:
+//
+//	rec, err := sfapitest.NewRecorder("testdata/coverage.json", sfapitest.ModeFromEnv())
+//	defer rec.Save()
+//	con.HttpClient = rec.Client()
+//
+// Requests are matched by method, path and query parameters, with whitespace
+// in SOQL normalized, so a cassette recorded against one org replays against
+// any base URL. Identical requests are replayed in the recorded order.
+// Authorization headers are never stored and secrets are scrubbed from bodies.
+type Recorder struct {
+	Mode Mode
+	Path string
+	// Transport makes the real requests when recording, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// ModeFromEnv returns ModeRecord if the SFAPI_RECORD environment variable is
+// set to a non-empty value, which lets the same test record or replay.
+func ModeFromEnv() Mode {
+	if os.Getenv("SFAPI_RECORD") != "" {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+// NewRecorder creates a Recorder for the cassette at path, which must exist for replay.
+func NewRecorder(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{Mode: mode, Path: path}
+	if mode == ModeRecord {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &r.cassette); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+
+	return r, nil
+}
+
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Save writes the recorded interactions to Path. It does nothing when replaying.
+func (r *Recorder) Save() error {
+	if r.Mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+
+	if err := os.WriteFile(r.Path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recReq := newRecordedRequest(req, reqBody)
+
+	if r.Mode == ModeRecord {
+		return r.record(req, recReq)
+	}
+
+	recResp, err := r.replay(recReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: recResp.StatusCode,
+		Status:     fmt.Sprintf("%d %s", recResp.StatusCode, http.StatusText(recResp.StatusCode)),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     recResp.Header.Clone(),
+		Body:       io.NopCloser(strings.NewReader(recResp.Body)),
+		Request:    req,
+	}, nil
+}
+
+func (r *Recorder) record(req *http.Request, recReq RecordedRequest) (*http.Response, error) {
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	header := sfapi.RedactHeader(resp.Header)
+	header.Del("Set-Cookie")
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: recReq,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     header,
+			Body:       scrubBody(resp.Header.Get("Content-Type"), respBody),
+		},
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+func (r *Recorder) replay(recReq RecordedRequest) (RecordedResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := -1
+	for i, in := range r.cassette.Interactions {
+		if !in.Request.matches(recReq) {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return in.Response, nil
+		}
+		last = i
+	}
+
+	// Requests repeated more often than recorded, e.g. polling, get the last response
+	if last >= 0 {
+		return r.cassette.Interactions[last].Response, nil
+	}
+
+	msg := "sfapitest: no recorded interaction in " + r.Path + " for " + recReq.Method + " " + recReq.Path
+	if len(recReq.Query) > 0 {
+		msg += "?" + recReq.Query.Encode()
+	}
+
+	return RecordedResponse{}, errors.New(msg)
+}
+
+func (rr RecordedRequest) matches(other RecordedRequest) bool {
+	if rr.Method != other.Method || rr.Path != other.Path || rr.Body != other.Body {
+		return false
+	}
+
+	if len(rr.Query) != len(other.Query) {
+		return false
+	}
+	for key, values := range rr.Query {
+		if !slices.Equal(values, other.Query[key]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRecordedRequest(req *http.Request, body []byte) RecordedRequest {
+	query := req.URL.Query()
+	for key, values := range query {
+		for i, v := range values {
+			if key == "q" {
+				v = strings.Join(strings.Fields(v), " ")
+			}
+			if slices.Contains(secretFields, strings.ToLower(key)) {
+				v = redacted
+			}
+			values[i] = v
+		}
+	}
+	if len(query) == 0 {
+		query = nil
+	}
+
+	return RecordedRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  query,
+		Body:   scrubBody(req.Header.Get("Content-Type"), body),
+	}
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
+
+// scrubBody replaces the values of secretFields in form encoded and JSON
+// bodies and those of secretElements in XML bodies.
+func scrubBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	if strings.Contains(contentType, "xml") || bytes.HasPrefix(bytes.TrimSpace(body), []byte("<")) {
+		for _, re := range secretElements {
+			body = re.ReplaceAll(body, []byte("${1}"+redacted+"${2}"))
+		}
+		return string(body)
+	}
+
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return redacted
+		}
+		for key := range form {
+			if slices.Contains(secretFields, strings.ToLower(key)) {
+				form.Set(key, redacted)
+			}
+		}
+		return form.Encode()
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return string(body)
+	}
+
+	scrubbed, err := json.Marshal(scrubJSON(value))
+	if err != nil {
+		return string(body)
+	}
+
+	return string(scrubbed)
+}
+
+func scrubJSON(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, nested := range v {
+			if slices.Contains(secretFields, strings.ToLower(key)) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = scrubJSON(nested)
+		}
+	case []any:
+		for i, nested := range v {
+			v[i] = scrubJSON(nested)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,100 @@
+package sfapitest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/achere/g-force/pkg/sfapi"
+	"github.com/achere/g-force/pkg/sfapi/rest"
+	"github.com/achere/g-force/pkg/sfapi/sfapitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	ctx := context.Background()
+
+	org := sfapitest.NewServer()
+	org.AddRecords("Account", sfapitest.Record{"Name": "Acme"})
+
+	rec, err := sfapitest.NewRecorder(path, sfapitest.ModeRecord)
+	require.NoError(t, err)
+
+	c := org.Connection()
+	c.HttpClient = rec.Client()
+
+	records, err := rest.Query(c, ctx, "SELECT Name FROM Account")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.NoError(t, rec.Save())
+	org.Close()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), sfapitest.AccessToken)
+	assert.NotContains(t, string(data), "fake-client-secret")
+
+	rec, err = sfapitest.NewRecorder(path, sfapitest.ModeReplay)
+	require.NoError(t, err)
+
+	c = &sfapi.Connection{
+		ApiVersion:   sfapitest.ApiVersion,
+		BaseUrl:      "https://replay.invalid",
+		ClientId:     "fake-client-id",
+		ClientSecret: "other-secret",
+		HttpClient:   rec.Client(),
+	}
+
+	records, err = rest.Query(c, ctx, "SELECT  Name\n FROM Account")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "Acme", records[0].Fields["Name"])
+
+	_, err = rest.Query(c, ctx, "SELECT Id FROM Contact")
+	assert.ErrorContains(t, err, "no recorded interaction")
+	assert.True(t, strings.Contains(err.Error(), "Contact"))
+}
+
+func TestRecorderSoap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	ctx := context.Background()
+	script := strings.Repeat("System.debug(1);\n", 1000)
+
+	org := sfapitest.NewServer()
+
+	rec, err := sfapitest.NewRecorder(path, sfapitest.ModeRecord)
+	require.NoError(t, err)
+
+	c := org.Connection()
+	c.HttpClient = rec.Client()
+
+	result, err := c.ExecuteAnonymous(ctx, script, sfapi.ExecuteAnonymousOptions{})
+	require.NoError(t, err)
+	require.True(t, result.Success)
+	require.NoError(t, rec.Save())
+	org.Close()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), sfapitest.AccessToken)
+	assert.Contains(t, string(data), "executeAnonymous")
+
+	rec, err = sfapitest.NewRecorder(path, sfapitest.ModeReplay)
+	require.NoError(t, err)
+
+	c = &sfapi.Connection{
+		ApiVersion:   sfapitest.ApiVersion,
+		BaseUrl:      "https://replay.invalid",
+		ClientId:     "fake-client-id",
+		ClientSecret: "other-secret",
+		HttpClient:   rec.Client(),
+	}
+
+	result, err = c.ExecuteAnonymous(ctx, script, sfapi.ExecuteAnonymousOptions{})
+	require.NoError(t, err)
+	assert.True(t, result.Success)
+}
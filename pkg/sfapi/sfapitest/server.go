@@ -0,0 +1,836 @@
+// Package sfapitest provides an in-memory fake Salesforce org for testing code
+// built on sfapi without a live org.
+//
+//	org := sfapitest.NewServer()
+//	defer org.Close()
+//	org.AddToolingRecords("ApexClass", sfapitest.Record{"Name": "MyClass"})
+//	classes, err := org.Connection().RequestApexClasses(ctx, []string{"MyClass"})
+//
+// The org serves the OAuth token endpoint, REST and Tooling API queries,
+// sObject Collections create, Tooling API sObject create, update and delete,
+// anonymous Apex execution through the Tooling and SOAP APIs with debug logs
+// and test runs. Queries are evaluated by a minimal SOQL interpreter that
+// supports selecting fields and relationship paths from a single object, WHERE
+// with comparison, IN, NOT IN, LIKE, AND, OR and NOT, ORDER BY, LIMIT and
+// OFFSET.
+//
+// For traffic the fake org can't serve, Recorder captures it from a real org
+// once and replays it in tests without credentials.
+package sfapitest
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achere/g-force/pkg/sfapi"
+)
+
+const (
+	ApiVersion = "60.0"
+	// AccessToken is the token the org issues until ExpireSessions is called.
+	AccessToken = "00D000000000000!fake-access-token"
+	OrgId       = "00D000000000000AAA"
+	UserId      = "005000000000000AAA"
+)
+
+// Record is a stored record. Lookups are nested Records, optionally with
+// an "attributes" entry naming their type for polymorphic relationships.
+type Record map[string]any
+
+// ExecuteAnonymousResult is the response of the executeAnonymous endpoint.
+// Its Log is ignored, the org writes its own.
+type ExecuteAnonymousResult = sfapi.ExecuteAnonymousResult
+
+// Fixtures is the JSON format accepted by LoadFixtures: records by object
+// name, separately for the REST and the Tooling API.
+type Fixtures struct {
+	Records map[string][]Record `json:"records"`
+	Tooling map[string][]Record `json:"tooling"`
+}
+
+type Server struct {
+	*httptest.Server
+
+	// ExecuteAnonymous handles anonymous Apex, by default every script succeeds.
+	ExecuteAnonymous func(body string) ExecuteAnonymousResult
+	// RunTest returns the ApexTestResult records (MethodName, Outcome,
+	// Message, StackTrace, RunTime) of a test class run through
+	// runTestsAsynchronous or runTestsSynchronous. By default every class has
+	// a single passing test method.
+	RunTest func(className string) []Record
+	// BatchSize is the number of records per page of query results,
+	// 2000 if not set. The rest is served from nextRecordsUrl.
+	BatchSize int
+
+	mu      sync.Mutex
+	records map[string][]Record
+	tooling map[string][]Record
+	cursors map[string][]Record
+	logs    map[string]string
+	lastId  int
+	session int
+}
+
+func NewServer() *Server {
+	s := &Server{
+		records: make(map[string][]Record),
+		tooling: make(map[string][]Record),
+		cursors: make(map[string][]Record),
+		logs:    make(map[string]string),
+	}
+	s.records["Organization"] = s.withIds([]Record{{"Id": OrgId, "IsSandbox": true, "NamespacePrefix": nil}})
+	// Objects the org writes to itself are queryable before it does.
+	for _, object := range []string{
+		"ApexTestQueueItem", "ApexTestResult", "ApexTestRunResult", "DebugLevel", "TraceFlag", "ApexLog",
+	} {
+		s.tooling[object] = []Record{}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /services/oauth2/token", s.handleToken)
+	mux.HandleFunc("GET /id/{orgId}/{userId}", s.authorized(s.handleIdentity))
+	mux.HandleFunc("GET /services/data/{version}/query/", s.authorized(s.handleQuery(false)))
+	mux.HandleFunc("GET /services/data/{version}/tooling/query/", s.authorized(s.handleQuery(true)))
+	mux.HandleFunc("GET /services/data/{version}/query/{locator}", s.authorized(s.handleQueryMore(false)))
+	mux.HandleFunc("GET /services/data/{version}/tooling/query/{locator}", s.authorized(s.handleQueryMore(true)))
+	mux.HandleFunc("POST /services/data/{version}/composite/sobjects", s.authorized(s.handleCollectionsCreate))
+	mux.HandleFunc("GET /services/data/{version}/tooling/executeAnonymous/", s.authorized(s.handleExecuteAnonymous))
+	mux.HandleFunc("POST /services/Soap/s/{version}", s.handleExecuteAnonymousSoap)
+	mux.HandleFunc("POST /services/data/{version}/tooling/sobjects/{object}/", s.authorized(s.handleToolingCreate))
+	mux.HandleFunc("PATCH /services/data/{version}/tooling/sobjects/{object}/{id}", s.authorized(s.handleToolingUpdate))
+	mux.HandleFunc("DELETE /services/data/{version}/tooling/sobjects/{object}/{id}", s.authorized(s.handleToolingDelete))
+	mux.HandleFunc("GET /services/data/{version}/tooling/sobjects/ApexLog/{id}/Body", s.authorized(s.handleApexLogBody))
+	mux.HandleFunc("POST /services/data/{version}/tooling/runTestsAsynchronous/", s.authorized(s.handleRunTestsAsynchronous))
+	mux.HandleFunc("POST /services/data/{version}/tooling/runTestsSynchronous/", s.authorized(s.handleRunTestsSynchronous))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The requested resource does not exist")
+	})
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Connection returns a Connection to the fake org using client credentials.
+func (s *Server) Connection() *sfapi.Connection {
+	return &sfapi.Connection{
+		ApiVersion:   ApiVersion,
+		BaseUrl:      s.URL,
+		ClientId:     "fake-client-id",
+		ClientSecret: "fake-client-secret",
+		HttpClient:   s.Client(),
+	}
+}
+
+// AddRecords stores records queryable with the REST API. Records without
+// an Id get a generated one.
+func (s *Server) AddRecords(object string, records ...Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[object] = append(s.records[object], s.withIds(records)...)
+}
+
+// AddToolingRecords stores records queryable with the Tooling API.
+func (s *Server) AddToolingRecords(object string, records ...Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tooling[object] = append(s.tooling[object], s.withIds(records)...)
+}
+
+// Records returns the REST API records of object, including ones created
+// through the API.
+func (s *Server) Records(object string) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.records[object])
+}
+
+// LoadFixtures adds records from JSON in the Fixtures format.
+func (s *Server) LoadFixtures(r io.Reader) error {
+	var fixtures Fixtures
+	if err := json.NewDecoder(r).Decode(&fixtures); err != nil {
+		return fmt.Errorf("json.Decoder.Decode: %w", err)
+	}
+
+	for object, records := range fixtures.Records {
+		s.AddRecords(object, records...)
+	}
+	for object, records := range fixtures.Tooling {
+		s.AddToolingRecords(object, records...)
+	}
+
+	return nil
+}
+
+// LoadFixtureFile adds records from a JSON file in the Fixtures format.
+func (s *Server) LoadFixtureFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("os.Open: %w", err)
+	}
+	defer f.Close()
+
+	return s.LoadFixtures(f)
+}
+
+func (s *Server) withIds(records []Record) []Record {
+	res := make([]Record, len(records))
+	for i, r := range records {
+		r = copyRecord(r)
+		if r.get("Id") == nil {
+			s.lastId++
+			r["Id"] = fmt.Sprintf("000%015d", s.lastId)
+		}
+		res[i] = r
+	}
+
+	return res
+}
+
+// SetNamespace turns the org into a namespaced dev or scratch org. Its own
+// Apex records are expected to carry ns as NamespacePrefix.
+func (s *Server) SetNamespace(ns string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records["Organization"][0]["NamespacePrefix"] = ns
+}
+
+// ExpireSessions invalidates the access tokens issued so far, so requests
+// with them fail like with an expired session until a new token is requested.
+func (s *Server) ExpireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.session++
+}
+
+func (s *Server) accessToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.session == 0 {
+		return AccessToken
+	}
+	return AccessToken + "-" + strconv.Itoa(s.session)
+}
+
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.accessToken() {
+			writeError(w, http.StatusUnauthorized, "INVALID_SESSION_ID", "Session expired or invalid")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, sfapi.TokenResponse{
+		AccessToken: s.accessToken(),
+		InstanceUrl: s.URL,
+		Id:          s.URL + "/id/" + OrgId + "/" + UserId,
+	})
+}
+
+func (s *Server) handleIdentity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, sfapi.Identity{
+		Id:             s.URL + r.URL.Path,
+		UserId:         UserId,
+		OrganizationId: OrgId,
+		Username:       "user@example.com",
+	})
+}
+
+func (s *Server) handleQuery(tooling bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseQuery(r.URL.Query().Get("q"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "MALFORMED_QUERY", err.Error())
+			return
+		}
+
+		s.mu.Lock()
+		store := s.records
+		if tooling {
+			store = s.tooling
+		}
+		records, ok := store[q.object]
+		records = slices.Clone(records)
+		s.mu.Unlock()
+
+		if !ok {
+			writeError(w, http.StatusBadRequest, "INVALID_TYPE", "sObject type '"+q.object+"' is not supported.")
+			return
+		}
+
+		result := q.run(records, "/services/data/"+r.PathValue("version"))
+		s.writeQueryPage(w, r, tooling, result, len(result))
+	}
+}
+
+func (s *Server) handleQueryMore(tooling bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		locator := r.PathValue("locator")
+
+		s.mu.Lock()
+		result, ok := s.cursors[locator]
+		delete(s.cursors, locator)
+		s.mu.Unlock()
+
+		if !ok {
+			writeError(w, http.StatusBadRequest, "INVALID_QUERY_LOCATOR", "invalid query locator")
+			return
+		}
+
+		totalSize, _ := strconv.Atoi(locator[strings.LastIndex(locator, "-")+1:])
+		s.writeQueryPage(w, r, tooling, result, totalSize)
+	}
+}
+
+// writeQueryPage responds with up to BatchSize records of result and keeps
+// the rest for the nextRecordsUrl. Locators end with the total size of the
+// query so that every page reports it.
+func (s *Server) writeQueryPage(w http.ResponseWriter, r *http.Request, tooling bool, result []Record, totalSize int) {
+	batchSize := s.BatchSize
+	if batchSize <= 0 {
+		batchSize = 2000
+	}
+
+	page := map[string]any{"totalSize": totalSize, "done": true}
+	if len(result) > batchSize {
+		s.mu.Lock()
+		s.lastId++
+		locator := fmt.Sprintf("01g%015d-%d", s.lastId, totalSize)
+		s.cursors[locator] = result[batchSize:]
+		s.mu.Unlock()
+
+		queryPath := "/services/data/" + r.PathValue("version") + "/query/"
+		if tooling {
+			queryPath = "/services/data/" + r.PathValue("version") + "/tooling/query/"
+		}
+		page["done"] = false
+		page["nextRecordsUrl"] = queryPath + locator
+		result = result[:batchSize]
+	}
+	page["records"] = result
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) handleCollectionsCreate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		AllOrNone bool     `json:"allOrNone"`
+		Records   []Record `json:"records"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "JSON_PARSER_ERROR", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]map[string]any, len(body.Records))
+	for i, rec := range body.Records {
+		attrs, _ := rec["attributes"].(map[string]any)
+		object, _ := attrs["type"].(string)
+		delete(rec, "attributes")
+
+		created := s.withIds([]Record{rec})[0]
+		s.records[object] = append(s.records[object], created)
+		results[i] = map[string]any{"id": created["Id"], "success": true, "errors": []any{}}
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// handleExecuteAnonymous also stores a debug log of the execution if the user
+// has a USER_DEBUG trace flag.
+func (s *Server) handleExecuteAnonymous(w http.ResponseWriter, r *http.Request) {
+	result, logBody := s.executeAnonymous(r.URL.Query().Get("anonymousBody"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.traced(UserId, sfapi.LogTypeUserDebug) {
+		log := s.withIds([]Record{{
+			"LogUserId": UserId,
+			"LogLength": len(logBody),
+			"Operation": r.URL.Path,
+			"Request":   "Api",
+			"Status":    "Success",
+			"StartTime": time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		}})[0]
+		if !result.Success {
+			log["Status"] = result.ExceptionMessage + result.CompileProblem
+		}
+		s.tooling["ApexLog"] = append(s.tooling["ApexLog"], log)
+		s.logs[log["Id"].(string)] = logBody
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// traced reports whether entityId has an unexpired trace flag of logType.
+func (s *Server) traced(entityId, logType any) bool {
+	now := time.Now()
+	return slices.ContainsFunc(s.tooling["TraceFlag"], func(flag Record) bool {
+		if flag.get("TracedEntityId") != entityId || flag.get("LogType") != logType {
+			return false
+		}
+		expiration, ok := toTime(flag.get("ExpirationDate"))
+		return !ok || expiration.After(now)
+	})
+}
+
+// handleExecuteAnonymousSoap serves executeAnonymous of the SOAP Apex API.
+// The debug log is returned in the response if the request has a
+// DebuggingHeader.
+func (s *Server) handleExecuteAnonymousSoap(w http.ResponseWriter, r *http.Request) {
+	var envelope struct {
+		SessionId string    `xml:"Header>SessionHeader>sessionId"`
+		Debugging *struct{} `xml:"Header>DebuggingHeader"`
+		Body      string    `xml:"Body>executeAnonymous>String"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		writeSoapFault(w, "soapenv:Client", err.Error())
+		return
+	}
+	if envelope.SessionId != s.accessToken() {
+		writeSoapFault(w, "sf:INVALID_SESSION_ID", "INVALID_SESSION_ID: Invalid Session ID found in SessionHeader")
+		return
+	}
+
+	result, logBody := s.executeAnonymous(envelope.Body)
+
+	var header string
+	if envelope.Debugging != nil {
+		header = "<soapenv:Header><DebuggingInfo><debugLog>" + escapeXml(logBody) + "</debugLog></DebuggingInfo></soapenv:Header>"
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+	io.WriteString(w, xml.Header+
+		`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns="http://soap.sforce.com/2006/08/apex">`+
+		header+
+		"<soapenv:Body><executeAnonymousResponse><result>"+
+		"<column>"+strconv.Itoa(result.Column)+"</column>"+
+		"<compileProblem>"+escapeXml(result.CompileProblem)+"</compileProblem>"+
+		"<compiled>"+strconv.FormatBool(result.Compiled)+"</compiled>"+
+		"<exceptionMessage>"+escapeXml(result.ExceptionMessage)+"</exceptionMessage>"+
+		"<exceptionStackTrace>"+escapeXml(result.ExceptionStackTrace)+"</exceptionStackTrace>"+
+		"<line>"+strconv.Itoa(result.Line)+"</line>"+
+		"<success>"+strconv.FormatBool(result.Success)+"</success>"+
+		"</result></executeAnonymousResponse></soapenv:Body></soapenv:Envelope>")
+}
+
+// executeAnonymous runs the ExecuteAnonymous hook and returns the result with
+// the body of its debug log.
+func (s *Server) executeAnonymous(body string) (ExecuteAnonymousResult, string) {
+	result := ExecuteAnonymousResult{Compiled: true, Success: true, Line: -1, Column: -1}
+	if s.ExecuteAnonymous != nil {
+		result = s.ExecuteAnonymous(body)
+	}
+	result.Log = ""
+
+	logBody := "Execute Anonymous: " + body + "\n"
+	if !result.Success {
+		logBody += "FATAL_ERROR " + result.ExceptionMessage + result.CompileProblem + "\n"
+	}
+
+	return result, logBody
+}
+
+func (s *Server) handleToolingCreate(w http.ResponseWriter, r *http.Request) {
+	var rec Record
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		writeError(w, http.StatusBadRequest, "JSON_PARSER_ERROR", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object := r.PathValue("object")
+	if object == "TraceFlag" && s.traced(rec.get("TracedEntityId"), rec.get("LogType")) {
+		writeError(w, http.StatusBadRequest, "FIELD_INTEGRITY_EXCEPTION", "This entity is already being traced.")
+		return
+	}
+
+	created := s.withIds([]Record{rec})[0]
+	s.tooling[object] = append(s.tooling[object], created)
+
+	writeJSON(w, http.StatusCreated, map[string]any{"id": created["Id"], "success": true, "errors": []any{}})
+}
+
+func (s *Server) handleToolingUpdate(w http.ResponseWriter, r *http.Request) {
+	var fields Record
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		writeError(w, http.StatusBadRequest, "JSON_PARSER_ERROR", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, id := r.PathValue("object"), r.PathValue("id")
+	i := slices.IndexFunc(s.tooling[object], func(rec Record) bool {
+		return rec.get("Id") == id
+	})
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The requested resource does not exist")
+		return
+	}
+
+	for k, v := range fields {
+		s.tooling[object][i][k] = v
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleToolingDelete(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, id := r.PathValue("object"), r.PathValue("id")
+	n := len(s.tooling[object])
+	s.tooling[object] = slices.DeleteFunc(s.tooling[object], func(rec Record) bool {
+		return rec.get("Id") == id
+	})
+	if len(s.tooling[object]) == n {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The requested resource does not exist")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleApexLogBody(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	body, ok := s.logs[r.PathValue("id")]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "The requested resource does not exist")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, body)
+}
+
+// handleRunTestsAsynchronous runs the tests right away and stores the records
+// of a finished run, so the first poll sees it completed.
+func (s *Server) handleRunTestsAsynchronous(w http.ResponseWriter, r *http.Request) {
+	classNames, err := decodeTestClassNames(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "JSON_PARSER_ERROR", err.Error())
+		return
+	}
+
+	results := s.runTests(classNames)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastId++
+	jobId := fmt.Sprintf("707%015d", s.lastId)
+
+	var failed int
+	for _, class := range classNames {
+		s.tooling["ApexTestQueueItem"] = append(s.tooling["ApexTestQueueItem"], s.withIds([]Record{{
+			"ApexClass":   s.apexClass(class),
+			"Status":      sfapi.TestStatusCompleted,
+			"ParentJobId": jobId,
+		}})...)
+	}
+	for _, res := range results {
+		res["AsyncApexJobId"] = jobId
+		if res["Outcome"] != sfapi.TestOutcomePass {
+			failed++
+		}
+	}
+	s.tooling["ApexTestResult"] = append(s.tooling["ApexTestResult"], s.withIds(results)...)
+	s.tooling["ApexTestRunResult"] = append(s.tooling["ApexTestRunResult"], s.withIds([]Record{{
+		"AsyncApexJobId":   jobId,
+		"Status":           sfapi.TestStatusCompleted,
+		"ClassesCompleted": len(classNames),
+		"ClassesEnqueued":  len(classNames),
+		"MethodsCompleted": len(results),
+		"MethodsEnqueued":  len(results),
+		"MethodsFailed":    failed,
+	}})...)
+
+	writeJSON(w, http.StatusOK, jobId)
+}
+
+func (s *Server) handleRunTestsSynchronous(w http.ResponseWriter, r *http.Request) {
+	classNames, err := decodeTestClassNames(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "JSON_PARSER_ERROR", err.Error())
+		return
+	}
+
+	result := sfapi.RunTestsResult{
+		Successes: []sfapi.RunTestsResult_Success{},
+		Failures:  []sfapi.RunTestsResult_Failure{},
+	}
+	for _, res := range s.runTests(classNames) {
+		class, _ := asRecord(res["ApexClass"])
+		name, _ := class["Name"].(string)
+		methodName, _ := res["MethodName"].(string)
+		result.NumTestsRun++
+
+		if res["Outcome"] == sfapi.TestOutcomePass {
+			result.Successes = append(result.Successes, sfapi.RunTestsResult_Success{
+				Name:       name,
+				MethodName: methodName,
+			})
+			continue
+		}
+
+		message, _ := res["Message"].(string)
+		stackTrace, _ := res["StackTrace"].(string)
+		result.NumFailures++
+		result.Failures = append(result.Failures, sfapi.RunTestsResult_Failure{
+			Name:       name,
+			MethodName: methodName,
+			Message:    message,
+			StackTrace: stackTrace,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// runTests returns the ApexTestResult records of classNames.
+func (s *Server) runTests(classNames []string) []Record {
+	var results []Record
+	for _, class := range classNames {
+		classResults := []Record{{"MethodName": "test", "Outcome": sfapi.TestOutcomePass, "RunTime": 1}}
+		if s.RunTest != nil {
+			classResults = s.RunTest(class)
+		}
+
+		s.mu.Lock()
+		apexClass := s.apexClass(class)
+		s.mu.Unlock()
+
+		for _, res := range classResults {
+			res = copyRecord(res)
+			res["ApexClass"] = apexClass
+			results = append(results, res)
+		}
+	}
+
+	return results
+}
+
+// apexClass returns the stored ApexClass named name as a lookup value.
+func (s *Server) apexClass(name string) Record {
+	for _, class := range s.tooling["ApexClass"] {
+		if strings.EqualFold(fmt.Sprint(class.get("Name")), name) {
+			return Record{"attributes": map[string]any{"type": "ApexClass"}, "Id": class.get("Id"), "Name": class.get("Name")}
+		}
+	}
+
+	return Record{"attributes": map[string]any{"type": "ApexClass"}, "Name": name}
+}
+
+func decodeTestClassNames(r io.Reader) ([]string, error) {
+	var body struct {
+		ClassNames string                       `json:"classNames"`
+		Tests      []sfapi.RunTestsRequest_Test `json:"tests"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	var classNames []string
+	if body.ClassNames != "" {
+		classNames = strings.Split(body.ClassNames, ",")
+	}
+	for _, t := range body.Tests {
+		classNames = append(classNames, t.ClassName)
+	}
+
+	return classNames, nil
+}
+
+func (q *query) run(records []Record, basePath string) []Record {
+	var matched []Record
+	for _, r := range records {
+		if q.where == nil || q.where.match(r) {
+			matched = append(matched, r)
+		}
+	}
+
+	if len(q.orderBy) > 0 {
+		slices.SortStableFunc(matched, func(a, b Record) int {
+			for _, o := range q.orderBy {
+				cmp := compareForSort(a.get(o.field), b.get(o.field))
+				if o.desc {
+					cmp = -cmp
+				}
+				if cmp != 0 {
+					return cmp
+				}
+			}
+			return 0
+		})
+	}
+
+	matched = matched[min(q.offset, len(matched)):]
+	if q.limit >= 0 && q.limit < len(matched) {
+		matched = matched[:q.limit]
+	}
+
+	res := make([]Record, len(matched))
+	for i, r := range matched {
+		res[i] = r.project(q.object, q.fields, basePath)
+	}
+
+	return res
+}
+
+// compareForSort puts nulls first like SOQL does for ascending order.
+func compareForSort(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return compare(a, b)
+}
+
+// project builds the API representation of r with only the selected fields.
+func (r Record) project(object string, fields []string, basePath string) Record {
+	out := Record{"attributes": map[string]any{
+		"type": object,
+		"url":  basePath + "/sobjects/" + object + "/" + fmt.Sprint(r.get("Id")),
+	}}
+
+	for _, f := range fields {
+		path := strings.Split(f, ".")
+		src, dst := r, out
+		for i, part := range path {
+			key := src.key(part)
+			if i == len(path)-1 {
+				dst[key] = src[key]
+				break
+			}
+
+			nested, ok := asRecord(src[key])
+			if !ok {
+				dst[key] = nil
+				break
+			}
+
+			nestedOut, ok := asRecord(dst[key])
+			if !ok {
+				nestedOut = Record{}
+				if attrs, ok := nested["attributes"]; ok {
+					nestedOut["attributes"] = attrs
+				}
+				dst[key] = nestedOut
+			}
+			src, dst = nested, nestedOut
+		}
+	}
+
+	return out
+}
+
+// get resolves a field path like ApexClassOrTrigger.Name, ignoring case.
+func (r Record) get(path string) any {
+	var value any = r
+	for _, part := range strings.Split(path, ".") {
+		rec, ok := asRecord(value)
+		if !ok {
+			return nil
+		}
+		value = rec[rec.key(part)]
+	}
+
+	return value
+}
+
+// key returns the stored spelling of a field name, which SOQL matches case-insensitively.
+func (r Record) key(name string) string {
+	if _, ok := r[name]; ok {
+		return name
+	}
+	for k := range r {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+
+	return name
+}
+
+func asRecord(v any) (Record, bool) {
+	switch rec := v.(type) {
+	case Record:
+		return rec, true
+	case map[string]any:
+		return Record(rec), true
+	}
+
+	return nil, false
+}
+
+// copyRecord copies r so that stored records aren't shared with the caller.
+func copyRecord(r Record) Record {
+	res := make(Record, len(r))
+	for k, v := range r {
+		if nested, ok := asRecord(v); ok {
+			v = copyRecord(nested)
+		}
+		res[k] = v
+	}
+
+	return res
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Sforce-Limit-Info", "api-usage=1/15000")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, []sfapi.APIErrorItem{{ErrorCode: code, Message: message}})
+}
+
+func writeSoapFault(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	io.WriteString(w, xml.Header+
+		`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><soapenv:Fault>`+
+		"<faultcode>"+escapeXml(code)+"</faultcode><faultstring>"+escapeXml(message)+"</faultstring>"+
+		"</soapenv:Fault></soapenv:Body></soapenv:Envelope>")
+}
+
+func escapeXml(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
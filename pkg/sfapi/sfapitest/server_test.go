@@ -0,0 +1,365 @@
+package sfapitest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achere/g-force/pkg/sfapi"
+	"github.com/achere/g-force/pkg/sfapi/rest"
+	"github.com/achere/g-force/pkg/sfapi/sfapitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+
+	err := org.LoadFixtures(strings.NewReader(`{
+		"records": {"Account": [{"Name": "Acme"}]},
+		"tooling": {"ApexClass": [
+			{"Name": "Class1", "SymbolTable": {"tableDeclaration": {"annotations": [], "modifiers": ["public"]}}},
+			{"Name": "Class1_Test", "SymbolTable": {"tableDeclaration": {"annotations": [{"name": "IsTest"}]}}}
+		]}
+	}`))
+	require.NoError(t, err)
+
+	c := org.Connection()
+	ctx := context.Background()
+
+	t.Run("Tooling query", func(t *testing.T) {
+		classes, err := c.RequestApexClasses(ctx, []string{"Class1_Test"})
+		require.NoError(t, err)
+		require.Len(t, classes, 1)
+		assert.Equal(t, "IsTest", classes[0].SymbolTable.TableDeclaration.Annotations[0].Name)
+	})
+
+	t.Run("Tooling query with own struct", func(t *testing.T) {
+		org.AddToolingRecords("ApexTrigger", sfapitest.Record{"Name": "AccountTrigger", "TableEnumOrId": "Account"})
+
+		type apexTrigger struct {
+			Name          string `json:"Name"`
+			TableEnumOrId string `json:"TableEnumOrId"`
+		}
+		triggers, err := sfapi.ToolingQuery[apexTrigger](c, ctx, "SELECT Name, TableEnumOrId FROM ApexTrigger")
+		require.NoError(t, err)
+		assert.Equal(t, []apexTrigger{{Name: "AccountTrigger", TableEnumOrId: "Account"}}, triggers)
+
+		records, err := rest.ToolingQuery(c, ctx, "SELECT Name FROM ApexTrigger")
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		assert.Equal(t, "ApexTrigger", records[0].Attributes.Type)
+		assert.Equal(t, "AccountTrigger", records[0].Fields["Name"])
+	})
+
+	t.Run("Create and query", func(t *testing.T) {
+		_, err := rest.CollectionsCreate(c, ctx, true, []rest.CollectionsRecord{{
+			Attributes: rest.CollectionRecord_Attributes{Type: "Account"},
+			Fields:     map[string]any{"Name": "Globex"},
+		}})
+		require.NoError(t, err)
+
+		records, err := rest.Query(c, ctx, "SELECT Id, Name FROM Account ORDER BY Name")
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		assert.Equal(t, "Acme", records[0].Fields["Name"])
+		assert.Equal(t, "Globex", records[1].Fields["Name"])
+		assert.Len(t, org.Records("Account"), 2)
+	})
+
+	t.Run("Malformed query", func(t *testing.T) {
+		_, err := rest.Query(c, ctx, "SELECT FROM Account")
+
+		var apiErr *sfapi.APIError
+		require.True(t, errors.As(err, &apiErr))
+		assert.True(t, apiErr.HasErrorCode("MALFORMED_QUERY"))
+	})
+
+	t.Run("Execute anonymous", func(t *testing.T) {
+		org.ExecuteAnonymous = func(body string) sfapitest.ExecuteAnonymousResult {
+			return sfapitest.ExecuteAnonymousResult{CompileProblem: "Unexpected token", Line: 1, Column: 5}
+		}
+		defer func() { org.ExecuteAnonymous = nil }()
+
+		assert.ErrorContains(t, c.ExecuteAnonymousRest(ctx, "System.debug(;"), "Unexpected token")
+		org.ExecuteAnonymous = nil
+		assert.NoError(t, c.ExecuteAnonymousRest(ctx, "System.debug(1);"))
+	})
+}
+
+func TestServerPagination(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+	org.BatchSize = 2
+
+	for i := range 5 {
+		org.AddRecords("Account", sfapitest.Record{"Name": fmt.Sprintf("Account%d", i)})
+		org.AddToolingRecords("ApexClass", sfapitest.Record{"Name": fmt.Sprintf("Class%d", i)})
+	}
+
+	c := org.Connection()
+	ctx := context.Background()
+
+	t.Run("Tooling pages", func(t *testing.T) {
+		var sizes, totals []int
+		err := sfapi.ToolingQueryPages(c, ctx, "SELECT Id, Name FROM ApexClass",
+			func(page sfapi.QueryPage[sfapi.ApexClass]) error {
+				sizes = append(sizes, len(page.Records))
+				totals = append(totals, page.TotalSize)
+				return nil
+			},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, []int{2, 2, 1}, sizes)
+		assert.Equal(t, []int{5, 5, 5}, totals)
+	})
+
+	t.Run("Stop early", func(t *testing.T) {
+		errStop := errors.New("stop")
+		pages := 0
+		err := sfapi.ToolingQueryPages(c, ctx, "SELECT Id, Name FROM ApexClass",
+			func(page sfapi.QueryPage[sfapi.ApexClass]) error {
+				pages++
+				return errStop
+			},
+		)
+		assert.ErrorIs(t, err, errStop)
+		assert.Equal(t, 1, pages)
+	})
+
+	t.Run("All tooling records", func(t *testing.T) {
+		names := []string{"Class0", "Class1", "Class2", "Class3", "Class4"}
+		classes, err := c.RequestApexClasses(ctx, names)
+		require.NoError(t, err)
+		assert.Len(t, classes, 5)
+	})
+
+	t.Run("All REST records", func(t *testing.T) {
+		records, err := rest.Query(c, ctx, "SELECT Name FROM Account ORDER BY Name DESC")
+		require.NoError(t, err)
+		require.Len(t, records, 5)
+		assert.Equal(t, "Account4", records[0].Fields["Name"])
+		assert.Equal(t, "Account0", records[4].Fields["Name"])
+	})
+}
+
+func TestServerRunTests(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+	org.AddToolingRecords("ApexClass", sfapitest.Record{"Name": "Class1_Test"}, sfapitest.Record{"Name": "Class2_Test"})
+	org.RunTest = func(className string) []sfapitest.Record {
+		if className == "Class2_Test" {
+			return []sfapitest.Record{{"MethodName": "fails", "Outcome": sfapi.TestOutcomeFail, "Message": "assertion failed"}}
+		}
+		return []sfapitest.Record{{"MethodName": "passes", "Outcome": sfapi.TestOutcomePass}}
+	}
+
+	c := org.Connection()
+	ctx := context.Background()
+
+	t.Run("Asynchronous", func(t *testing.T) {
+		var progress []sfapi.TestRunProgress
+		results, err := c.RunTests(ctx, sfapi.RunTestsRequest{
+			ClassNames: []string{"Class2_Test", "Class1_Test"},
+			TestLevel:  sfapi.TestLevelRunSpecifiedTests,
+		}, sfapi.TestRunOptions{
+			OnProgress: func(p sfapi.TestRunProgress) { progress = append(progress, p) },
+		})
+		require.NoError(t, err)
+
+		require.Len(t, progress, 1)
+		assert.Equal(t, 1, progress[0].RunResult.MethodsFailed)
+		assert.Len(t, progress[0].QueueItems, 2)
+
+		require.Len(t, results, 2)
+		assert.Equal(t, "Class1_Test", results[0].ApexClass.Name)
+		assert.NotEmpty(t, results[0].ApexClass.Id)
+		assert.Equal(t, sfapi.TestOutcomePass, results[0].Outcome)
+		assert.Equal(t, "Class2_Test", results[1].ApexClass.Name)
+		assert.Equal(t, "assertion failed", results[1].Message)
+	})
+
+	t.Run("Synchronous", func(t *testing.T) {
+		result, err := c.RunTestsSynchronous(ctx, sfapi.RunTestsRequest{
+			Tests: []sfapi.RunTestsRequest_Test{{ClassName: "Class2_Test", TestMethods: []string{"fails"}}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.NumTestsRun)
+		assert.Equal(t, 1, result.NumFailures)
+		assert.Equal(t, "fails", result.Failures[0].MethodName)
+	})
+}
+
+func TestServerIdentifyConcurrently(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+
+	c := org.Connection()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			identity, err := c.Identify(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, sfapitest.UserId, identity.UserId)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServerDebugLogs(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+	org.ExecuteAnonymous = func(body string) sfapitest.ExecuteAnonymousResult {
+		return sfapitest.ExecuteAnonymousResult{Compiled: true, ExceptionMessage: "System.NullPointerException"}
+	}
+
+	c := org.Connection()
+	ctx := context.Background()
+
+	log, err := c.ExecuteAnonymousWithLog(ctx, "System.debug(null.x);")
+	assert.ErrorContains(t, err, "System.NullPointerException")
+	assert.Contains(t, log, "System.debug(null.x);")
+	assert.Contains(t, log, "FATAL_ERROR System.NullPointerException")
+	assert.Equal(t, sfapitest.UserId, c.UserId)
+
+	for _, object := range []string{"TraceFlag", "DebugLevel"} {
+		records, err := rest.ToolingQuery(c, ctx, "SELECT Id FROM "+object)
+		require.NoError(t, err)
+		assert.Empty(t, records, "%s records must be deleted", object)
+	}
+
+	const layout = "2006-01-02T15:04:05.000-0700"
+	queryFlag := func(t *testing.T, userId string) sfapi.TraceFlag {
+		flags, err := sfapi.ToolingQuery[sfapi.TraceFlag](
+			c, ctx, "SELECT Id, DebugLevelId, StartDate, ExpirationDate FROM TraceFlag WHERE TracedEntityId = '"+userId+"'",
+		)
+		require.NoError(t, err)
+		require.Len(t, flags, 1)
+		return flags[0]
+	}
+
+	t.Run("Active trace flag", func(t *testing.T) {
+		org.AddToolingRecords("DebugLevel", sfapitest.Record{"Id": "7dl000000000001AAA", "ApexCode": "NONE"})
+		org.AddToolingRecords("TraceFlag", sfapitest.Record{
+			"TracedEntityId": sfapitest.UserId,
+			"DebugLevelId":   "7dl000000000001AAA",
+			"LogType":        sfapi.LogTypeUserDebug,
+			"ExpirationDate": time.Now().Add(24 * time.Hour).UTC().Format(layout),
+		})
+		before := queryFlag(t, sfapitest.UserId)
+
+		session, err := c.StartDebugLogs(ctx, sfapitest.UserId, time.Hour)
+		require.NoError(t, err)
+		during := queryFlag(t, sfapitest.UserId)
+		assert.Equal(t, before.Id, during.Id)
+		assert.NotEqual(t, before.DebugLevelId, during.DebugLevelId, "the session must log with its own debug level")
+		assert.Equal(t, before.ExpirationDate, during.ExpirationDate)
+
+		require.NoError(t, c.StopDebugLogs(ctx, session))
+		assert.Equal(t, before, queryFlag(t, sfapitest.UserId))
+
+		levels, err := rest.ToolingQuery(c, ctx, "SELECT Id FROM DebugLevel")
+		require.NoError(t, err)
+		assert.Len(t, levels, 1)
+	})
+
+	t.Run("Trace flag expiring sooner", func(t *testing.T) {
+		const userId = "005000000000001AAA"
+		org.AddToolingRecords("TraceFlag", sfapitest.Record{
+			"TracedEntityId": userId,
+			"LogType":        sfapi.LogTypeUserDebug,
+			"StartDate":      time.Now().Add(-23 * time.Hour).UTC().Format(layout),
+			"ExpirationDate": time.Now().Add(5 * time.Minute).UTC().Format(layout),
+		})
+		before := queryFlag(t, userId)
+
+		session, err := c.StartDebugLogs(ctx, userId, time.Hour)
+		require.NoError(t, err)
+
+		during := queryFlag(t, userId)
+		expiration, err := time.Parse(layout, during.ExpirationDate)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expiration, time.Minute)
+		start, err := time.Parse(layout, during.StartDate)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), start, time.Minute, "the flag must not be active for over 24 hours")
+
+		require.NoError(t, c.StopDebugLogs(ctx, session))
+		after := queryFlag(t, userId)
+		assert.Equal(t, before.StartDate, after.StartDate)
+		assert.Equal(t, before.ExpirationDate, after.ExpirationDate)
+	})
+
+	t.Run("Too long", func(t *testing.T) {
+		_, err := c.StartDebugLogs(ctx, sfapitest.UserId, 25*time.Hour)
+		assert.ErrorContains(t, err, "at most 24 hours")
+	})
+}
+
+func TestServerExecuteAnonymous(t *testing.T) {
+	org := sfapitest.NewServer()
+	defer org.Close()
+
+	var executed []string
+	org.ExecuteAnonymous = func(body string) sfapitest.ExecuteAnonymousResult {
+		executed = append(executed, body)
+		if strings.Contains(body, "null.x") {
+			return sfapitest.ExecuteAnonymousResult{
+				Compiled: true, Line: 2, Column: 1, ExceptionMessage: "System.NullPointerException",
+			}
+		}
+		return sfapitest.ExecuteAnonymousResult{Compiled: true, Success: true, Line: -1, Column: -1}
+	}
+
+	c := org.Connection()
+	ctx := context.Background()
+
+	t.Run("Line comments", func(t *testing.T) {
+		script := "// no-op\nSystem.debug(1);\n"
+		result, err := c.ExecuteAnonymous(ctx, script, sfapi.ExecuteAnonymousOptions{})
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.NoError(t, result.Err())
+		assert.Equal(t, script, executed[len(executed)-1])
+	})
+
+	t.Run("Exception", func(t *testing.T) {
+		result, err := c.ExecuteAnonymous(ctx, "Object x;\nnull.x;", sfapi.ExecuteAnonymousOptions{})
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		assert.Equal(t, 2, result.Line)
+		assert.EqualError(t, result.Err(), "error on line 2:1 - System.NullPointerException ")
+	})
+
+	t.Run("Long script over SOAP", func(t *testing.T) {
+		script := strings.Repeat("System.debug('<&>');\n", 1000)
+		result, err := c.ExecuteAnonymous(ctx, script, sfapi.ExecuteAnonymousOptions{Log: true})
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.Equal(t, -1, result.Line)
+		assert.Equal(t, script, executed[len(executed)-1])
+		assert.Contains(t, result.Log, "System.debug('<&>');")
+
+		flags, err := rest.ToolingQuery(c, ctx, "SELECT Id FROM TraceFlag")
+		require.NoError(t, err)
+		assert.Empty(t, flags)
+	})
+
+	t.Run("Expired session over SOAP", func(t *testing.T) {
+		org.ExpireSessions()
+		script := strings.Repeat("System.debug(1);\n", 1000)
+		result, err := c.ExecuteAnonymous(ctx, script, sfapi.ExecuteAnonymousOptions{})
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.Equal(t, script, executed[len(executed)-1])
+	})
+}
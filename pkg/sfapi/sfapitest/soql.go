@@ -0,0 +1,529 @@
+package sfapitest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// query is a parsed SOQL statement supported by the fake org: a SELECT of
+// fields (including relationship paths like ApexTestClass.Name) from a single
+// object with optional WHERE, ORDER BY, LIMIT and OFFSET clauses.
+type query struct {
+	fields  []string
+	object  string
+	where   condition
+	orderBy []orderField
+	limit   int
+	offset  int
+}
+
+type orderField struct {
+	field string
+	desc  bool
+}
+
+type condition interface {
+	match(r Record) bool
+}
+
+type andCondition []condition
+
+func (c andCondition) match(r Record) bool {
+	for _, cond := range c {
+		if !cond.match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+type orCondition []condition
+
+func (c orCondition) match(r Record) bool {
+	for _, cond := range c {
+		if cond.match(r) {
+			return true
+		}
+	}
+	return false
+}
+
+type notCondition struct {
+	cond condition
+}
+
+func (c notCondition) match(r Record) bool {
+	return !c.cond.match(r)
+}
+
+type comparison struct {
+	field  string
+	op     string
+	values []any
+}
+
+func (c comparison) match(r Record) bool {
+	value := r.get(c.field)
+
+	switch c.op {
+	case "IN":
+		return slices.ContainsFunc(c.values, func(v any) bool { return compare(value, v) == 0 })
+	case "NOT IN":
+		return !slices.ContainsFunc(c.values, func(v any) bool { return compare(value, v) == 0 })
+	case "LIKE":
+		s, ok := value.(string)
+		pattern, okPattern := c.values[0].(string)
+		return ok && okPattern && likeToRegexp(pattern).MatchString(s)
+	case "=":
+		return compare(value, c.values[0]) == 0
+	case "!=", "<>":
+		return compare(value, c.values[0]) != 0
+	}
+
+	if value == nil || c.values[0] == nil {
+		return false
+	}
+
+	cmp := compare(value, c.values[0])
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+
+	return false
+}
+
+// compare orders values the way SOQL does: numbers numerically and strings
+// case-insensitively. null is only equal to null.
+func compare(a, b any) int {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0
+		}
+		return 1
+	}
+
+	an, aIsNum := toFloat(a)
+	bn, bIsNum := toFloat(b)
+	if aIsNum && bIsNum {
+		switch {
+		case an < bn:
+			return -1
+		case an > bn:
+			return 1
+		}
+		return 0
+	}
+
+	if ab, ok := a.(bool); ok {
+		if bb, ok := b.(bool); ok && ab == bb {
+			return 0
+		}
+		return 1
+	}
+
+	if at, ok := toTime(a); ok {
+		if bt, ok := toTime(b); ok {
+			return at.Compare(bt)
+		}
+	}
+
+	return strings.Compare(strings.ToLower(fmt.Sprint(a)), strings.ToLower(fmt.Sprint(b)))
+}
+
+// toTime parses date and dateTime values, both literals in queries and the
+// format the API returns.
+func toTime(v any) (time.Time, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000-0700", time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func likeToRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.MustCompile(sb.String())
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parseQuery(soql string) (*query, error) {
+	tokens, err := tokenize(soql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	q := &query{limit: -1}
+
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	for {
+		field, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		q.fields = append(q.fields, field)
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if q.object, err = p.word(); err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("WHERE") {
+		if q.where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			field, err := p.word()
+			if err != nil {
+				return nil, err
+			}
+			order := orderField{field: field}
+			if p.acceptKeyword("DESC") {
+				order.desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			q.orderBy = append(q.orderBy, order)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		if q.limit, err = p.integer(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("OFFSET") {
+		if q.offset, err = p.integer(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token '%s'", p.tokens[p.pos].value)
+	}
+
+	return q, nil
+}
+
+func (p *parser) parseOr() (condition, error) {
+	var conds orCondition
+	for {
+		cond, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+		if !p.acceptKeyword("OR") {
+			break
+		}
+	}
+
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return conds, nil
+}
+
+func (p *parser) parseAnd() (condition, error) {
+	var conds andCondition
+	for {
+		cond, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+		if !p.acceptKeyword("AND") {
+			break
+		}
+	}
+
+	if len(conds) == 1 {
+		return conds[0], nil
+	}
+	return conds, nil
+}
+
+func (p *parser) parseNot() (condition, error) {
+	if p.acceptKeyword("NOT") {
+		cond, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notCondition{cond: cond}, nil
+	}
+
+	if p.acceptPunct("(") {
+		cond, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptPunct(")") {
+			return nil, errors.New("expected ')'")
+		}
+		return cond, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (condition, error) {
+	field, err := p.word()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.acceptKeyword("IN"):
+		values, err := p.valueList()
+		return comparison{field: field, op: "IN", values: values}, err
+	case p.acceptKeyword("NOT"):
+		if err := p.expectKeyword("IN"); err != nil {
+			return nil, err
+		}
+		values, err := p.valueList()
+		return comparison{field: field, op: "NOT IN", values: values}, err
+	case p.acceptKeyword("LIKE"):
+		value, err := p.value()
+		return comparison{field: field, op: "LIKE", values: []any{value}}, err
+	}
+
+	for _, op := range []string{"=", "!=", "<>", "<=", ">=", "<", ">"} {
+		if p.acceptPunct(op) {
+			value, err := p.value()
+			return comparison{field: field, op: op, values: []any{value}}, err
+		}
+	}
+
+	return nil, fmt.Errorf("expected operator after '%s'", field)
+}
+
+func (p *parser) valueList() ([]any, error) {
+	if !p.acceptPunct("(") {
+		return nil, errors.New("expected '(' after IN")
+	}
+
+	var values []any
+	for {
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+
+	if !p.acceptPunct(")") {
+		return nil, errors.New("expected ')' to close IN list")
+	}
+
+	return values, nil
+}
+
+func (p *parser) value() (any, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of query, expected a value")
+	}
+
+	t := p.tokens[p.pos]
+	p.pos++
+
+	switch t.kind {
+	case tokenString:
+		return t.value, nil
+	case tokenPunct:
+		return nil, fmt.Errorf("unexpected token '%s', expected a value", t.value)
+	}
+
+	switch strings.ToLower(t.value) {
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	if n, err := strconv.ParseFloat(t.value, 64); err == nil {
+		return n, nil
+	}
+
+	// date and datetime literals are compared as times, see compare
+	return t.value, nil
+}
+
+func (p *parser) word() (string, error) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenWord {
+		return "", errors.New("expected a field or object name")
+	}
+
+	p.pos++
+	return p.tokens[p.pos-1].value, nil
+}
+
+func (p *parser) integer() (int, error) {
+	w, err := p.word()
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(w)
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenWord && strings.EqualFold(p.tokens[p.pos].value, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return errors.New("expected " + keyword)
+	}
+	return nil
+}
+
+func (p *parser) acceptPunct(punct string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenPunct && p.tokens[p.pos].value == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func tokenize(soql string) ([]token, error) {
+	var tokens []token
+	runes := []rune(soql)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			i++
+		case r == '\'':
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '\''; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case 'n':
+						sb.WriteRune('\n')
+					case 't':
+						sb.WriteRune('\t')
+					case 'r':
+						sb.WriteRune('\r')
+					default:
+						sb.WriteRune(runes[i])
+					}
+					continue
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated string literal")
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, value: sb.String()})
+		case strings.ContainsRune("(),", r):
+			tokens = append(tokens, token{kind: tokenPunct, value: string(r)})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			op := string(r)
+			if i+1 < len(runes) && strings.ContainsRune("=>", runes[i+1]) {
+				op += string(runes[i+1])
+			}
+			tokens = append(tokens, token{kind: tokenPunct, value: op})
+			i += len(op)
+		default:
+			start := i
+			for i < len(runes) && !strings.ContainsRune(" \t\n\r'(),=!<>", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, value: string(runes[start:i])})
+		}
+	}
+
+	return tokens, nil
+}
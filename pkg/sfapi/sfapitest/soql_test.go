@@ -0,0 +1,81 @@
+package sfapitest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryRun(t *testing.T) {
+	records := []Record{
+		{"Id": "1", "Name": "Acme", "Employees": 10, "Parent": Record{"Name": "Holding"}},
+		{"Id": "2", "Name": "O'Brien Ltd", "Employees": 250, "Parent": nil},
+		{"Id": "3", "Name": "acme labs", "Employees": 40, "Parent": Record{"Name": "Acme"}},
+	}
+
+	tests := []struct {
+		name    string
+		soql    string
+		wantIds []string
+		wantErr bool
+	}{
+		{name: "All", soql: "SELECT Id FROM Account", wantIds: []string{"1", "2", "3"}},
+		{name: "Equals ignores case", soql: "select Id from Account where Name = 'ACME'", wantIds: []string{"1"}},
+		{name: "Escaped quote", soql: `SELECT Id FROM Account WHERE Name IN ('O\'Brien Ltd', 'Other')`, wantIds: []string{"2"}},
+		{name: "Like", soql: "SELECT Id FROM Account WHERE Name LIKE 'acme%'", wantIds: []string{"1", "3"}},
+		{name: "Relationship", soql: "SELECT Id FROM Account WHERE Parent.Name = 'Acme'", wantIds: []string{"3"}},
+		{name: "Null", soql: "SELECT Id FROM Account WHERE Parent = null", wantIds: []string{"2"}},
+		{
+			name:    "And or not",
+			soql:    "SELECT Id FROM Account WHERE (Employees > 20 AND NOT Name LIKE 'acme%') OR Id = '1'",
+			wantIds: []string{"1", "2"},
+		},
+		{name: "Not in", soql: "SELECT Id FROM Account WHERE Id NOT IN ('1','2')", wantIds: []string{"3"}},
+		{
+			name:    "Order limit offset",
+			soql:    "SELECT Id FROM Account ORDER BY Employees DESC LIMIT 2 OFFSET 1",
+			wantIds: []string{"3", "1"},
+		},
+		{name: "Malformed", soql: "SELECT FROM Account", wantErr: true},
+		{name: "Unterminated string", soql: "SELECT Id FROM Account WHERE Name = 'x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parseQuery(tt.soql)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var ids []string
+			for _, r := range q.run(records, "") {
+				ids = append(ids, r["Id"].(string))
+			}
+			assert.Equal(t, tt.wantIds, ids)
+		})
+	}
+}
+
+func TestProject(t *testing.T) {
+	r := Record{
+		"Id":   "1",
+		"Name": "Test",
+		"ApexClassOrTrigger": Record{
+			"attributes": map[string]any{"type": "ApexTrigger"},
+			"Name":       "Trigger1",
+			"Id":         "01q",
+		},
+	}
+
+	got := r.project("ApexCodeCoverage", []string{"name", "ApexClassOrTrigger.Name"}, "/services/data/v60.0")
+
+	assert.Equal(t, "Test", got["Name"])
+	assert.NotContains(t, got, "Id")
+	assert.Equal(t, Record{
+		"attributes": map[string]any{"type": "ApexTrigger"},
+		"Name":       "Trigger1",
+	}, got["ApexClassOrTrigger"])
+}
@@ -0,0 +1,311 @@
+package sfapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const (
+	sfCliClientId       = "PlatformCLI"
+	sfCliKeychainName   = "sfdx"
+	sfCliKeychainAcct   = "local"
+	sfDefaultApiVersion = "60.0"
+)
+
+var sfEncryptedValue = regexp.MustCompile(`^[0-9a-f]+:[0-9a-f]{32}$`)
+
+// SfAuthInfo is the authorization of an org as stored by the sf (sfdx) CLI.
+type SfAuthInfo struct {
+	Username           string `json:"username"`
+	OrgId              string `json:"orgId"`
+	InstanceUrl        string `json:"instanceUrl"`
+	LoginUrl           string `json:"loginUrl"`
+	ClientId           string `json:"clientId"`
+	ClientSecret       string `json:"clientSecret"`
+	AccessToken        string `json:"accessToken"`
+	RefreshToken       string `json:"refreshToken"`
+	InstanceApiVersion string `json:"instanceApiVersion"`
+}
+
+// NewConnectionFromSfCli creates a Connection for an org authorized with the sf CLI.
+// aliasOrUsername is either an alias, a username or an sfdxAuthUrl
+// (force://<clientId>:<clientSecret>:<refreshToken>@<instanceUrl>).
+func NewConnectionFromSfCli(aliasOrUsername string) (*Connection, error) {
+	var (
+		auth SfAuthInfo
+		err  error
+	)
+	if strings.HasPrefix(aliasOrUsername, "force://") {
+		auth, err = ParseSfdxAuthUrl(aliasOrUsername)
+		if err != nil {
+			return nil, fmt.Errorf("ParseSfdxAuthUrl: %w", err)
+		}
+	} else {
+		auth, err = ReadSfAuthInfo(aliasOrUsername)
+		if err != nil {
+			return nil, fmt.Errorf("ReadSfAuthInfo: %w", err)
+		}
+	}
+
+	return auth.Connection()
+}
+
+// Connection creates a Connection that refreshes its access token with the
+// stored refresh token or, if there is none, uses the stored access token as is.
+func (a SfAuthInfo) Connection() (*Connection, error) {
+	baseUrl := a.InstanceUrl
+	if baseUrl == "" {
+		baseUrl = a.LoginUrl
+	}
+	if baseUrl == "" {
+		return nil, errors.New("auth info for " + a.Username + " has no instanceUrl")
+	}
+
+	apiVersion := a.InstanceApiVersion
+	if apiVersion == "" {
+		apiVersion = sfDefaultApiVersion
+	}
+
+	clientId := a.ClientId
+	if clientId == "" {
+		clientId = sfCliClientId
+	}
+
+	con := &Connection{
+		ApiVersion: apiVersion,
+		BaseUrl:    baseUrl,
+		OrgId:      a.OrgId,
+		ClientId:   clientId,
+		Username:   a.Username,
+	}
+
+	switch {
+	case a.RefreshToken != "":
+		con.TokenSource = &RefreshTokenSource{
+			BaseUrl:      baseUrl,
+			ClientId:     clientId,
+			ClientSecret: a.ClientSecret,
+			RefreshToken: a.RefreshToken,
+		}
+	case a.AccessToken != "":
+		con.TokenSource = &StaticTokenSource{AccessToken: a.AccessToken, InstanceUrl: a.InstanceUrl}
+	default:
+		return nil, errors.New("auth info for " + a.Username + " has neither refresh nor access token")
+	}
+
+	return con, nil
+}
+
+// ParseSfdxAuthUrl parses the sfdxAuthUrl format produced by
+// `sf org display --verbose`: force://<clientId>:<clientSecret>:<refreshToken>@<instanceUrl>.
+func ParseSfdxAuthUrl(authUrl string) (SfAuthInfo, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(authUrl), "force://")
+	if !ok {
+		return SfAuthInfo{}, errors.New("sfdxAuthUrl must start with force://")
+	}
+
+	at := strings.LastIndex(rest, "@")
+	if at == -1 {
+		return SfAuthInfo{}, errors.New("sfdxAuthUrl is missing the instance URL")
+	}
+
+	creds := strings.SplitN(rest[:at], ":", 3)
+	if len(creds) != 3 || creds[2] == "" {
+		return SfAuthInfo{}, errors.New("sfdxAuthUrl must contain clientId, clientSecret and refreshToken")
+	}
+
+	instanceUrl := strings.TrimSuffix(rest[at+1:], "/")
+	if !strings.HasPrefix(instanceUrl, "http://") && !strings.HasPrefix(instanceUrl, "https://") {
+		instanceUrl = "https://" + instanceUrl
+	}
+
+	return SfAuthInfo{
+		InstanceUrl:  instanceUrl,
+		ClientId:     creds[0],
+		ClientSecret: creds[1],
+		RefreshToken: creds[2],
+	}, nil
+}
+
+// ReadSfAuthInfo reads the auth file of an org from the sf CLI state folder
+// (~/.sfdx or $SFDX_DIR), resolving aliasOrUsername through alias.json.
+// Encrypted tokens are decrypted with the CLI's key from the generic keychain
+// file (key.json) or the OS keychain.
+func ReadSfAuthInfo(aliasOrUsername string) (SfAuthInfo, error) {
+	dir, err := sfStateDir()
+	if err != nil {
+		return SfAuthInfo{}, fmt.Errorf("sfStateDir: %w", err)
+	}
+
+	username, err := resolveSfAlias(dir, aliasOrUsername)
+	if err != nil {
+		return SfAuthInfo{}, fmt.Errorf("resolveSfAlias: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, username+".json"))
+	if err != nil {
+		return SfAuthInfo{}, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	var auth SfAuthInfo
+	if err := json.Unmarshal(data, &auth); err != nil {
+		return SfAuthInfo{}, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	if auth.Username == "" {
+		auth.Username = username
+	}
+
+	if !sfEncryptedValue.MatchString(auth.RefreshToken) &&
+		!sfEncryptedValue.MatchString(auth.AccessToken) &&
+		!sfEncryptedValue.MatchString(auth.ClientSecret) {
+		return auth, nil
+	}
+
+	key, err := sfCryptoKey(dir)
+	if err != nil {
+		return SfAuthInfo{}, fmt.Errorf("sfCryptoKey: %w", err)
+	}
+
+	for _, v := range []*string{&auth.RefreshToken, &auth.AccessToken, &auth.ClientSecret} {
+		if !sfEncryptedValue.MatchString(*v) {
+			continue
+		}
+		if *v, err = sfDecrypt(key, *v); err != nil {
+			return SfAuthInfo{}, fmt.Errorf("sfDecrypt: %w", err)
+		}
+	}
+
+	return auth, nil
+}
+
+func sfStateDir() (string, error) {
+	if dir := os.Getenv("SFDX_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("os.UserHomeDir: %w", err)
+	}
+
+	return filepath.Join(home, ".sfdx"), nil
+}
+
+func resolveSfAlias(dir, aliasOrUsername string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "alias.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return aliasOrUsername, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	var aliases struct {
+		Orgs map[string]string `json:"orgs"`
+	}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return "", fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	if username, ok := aliases.Orgs[aliasOrUsername]; ok {
+		return username, nil
+	}
+
+	return aliasOrUsername, nil
+}
+
+func sfCryptoKey(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "key.json"))
+	if err == nil {
+		var generic struct {
+			Key string `json:"key"`
+		}
+		if err := json.Unmarshal(data, &generic); err != nil {
+			return "", fmt.Errorf("json.Unmarshal: %w", err)
+		}
+		return generic.Key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-a", sfCliKeychainAcct, "-s", sfCliKeychainName, "-w")
+	case "linux":
+		cmd = exec.Command("secret-tool", "lookup", "user", sfCliKeychainAcct, "domain", sfCliKeychainName)
+	default:
+		return "", errors.New("reading the sf CLI key from the OS keychain is not supported on " + runtime.GOOS)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cmd.Path, err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// sfDecrypt reverses the AES-256-GCM encryption of the sf CLI. Values are stored
+// as <iv><ciphertext>:<tag>, all hex encoded. Older keys are 32 hex characters
+// used verbatim along with a 12 character IV, newer ones are 64 hex characters
+// decoded to bytes along with a 24 character IV.
+func sfDecrypt(key, value string) (string, error) {
+	payload, tagHex, _ := strings.Cut(value, ":")
+
+	var keyBytes, iv []byte
+	switch len(key) {
+	case 32:
+		if len(payload) < 12 {
+			return "", errors.New("encrypted value is too short")
+		}
+		keyBytes, iv, payload = []byte(key), []byte(payload[:12]), payload[12:]
+	case 64:
+		if len(payload) < 24 {
+			return "", errors.New("encrypted value is too short")
+		}
+		var err error
+		if keyBytes, err = hex.DecodeString(key); err != nil {
+			return "", fmt.Errorf("hex.DecodeString: %w", err)
+		}
+		if iv, err = hex.DecodeString(payload[:24]); err != nil {
+			return "", fmt.Errorf("hex.DecodeString: %w", err)
+		}
+		payload = payload[24:]
+	default:
+		return "", errors.New("unsupported sf CLI key length")
+	}
+
+	ciphertext, err := hex.DecodeString(payload + tagHex)
+	if err != nil {
+		return "", fmt.Errorf("hex.DecodeString: %w", err)
+	}
+
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		return "", fmt.Errorf("aes.NewCipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", fmt.Errorf("cipher.NewGCM: %w", err)
+	}
+
+	plain, err := gcm.Open(nil, iv, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("gcm.Open: %w", err)
+	}
+
+	return string(plain), nil
+}
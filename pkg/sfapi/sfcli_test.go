@@ -0,0 +1,111 @@
+package sfapi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSfAuthInfo(t *testing.T) {
+	const (
+		key          = "0123456789abcdef0123456789abcdef"
+		refreshToken = "5Aep861refresh"
+	)
+
+	dir := t.TempDir()
+	t.Setenv("SFDX_DIR", dir)
+
+	writeFile := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	writeFile("alias.json", `{"orgs":{"dev":"dev@example.com"}}`)
+	writeFile("key.json", `{"service":"sfdx","account":"local","key":"`+key+`"}`)
+	writeFile("dev@example.com.json", `{
+		"username": "dev@example.com",
+		"orgId": "00D000000000001",
+		"instanceUrl": "https://dev.my.salesforce.com",
+		"loginUrl": "https://login.salesforce.com",
+		"clientId": "PlatformCLI",
+		"refreshToken": "`+encryptSfV1(t, key, "abcdef012345", refreshToken)+`",
+		"instanceApiVersion": "61.0"
+	}`)
+
+	auth, err := ReadSfAuthInfo("dev")
+	require.NoError(t, err)
+	assert.Equal(t, refreshToken, auth.RefreshToken)
+	assert.Equal(t, "dev@example.com", auth.Username)
+
+	con, err := auth.Connection()
+	require.NoError(t, err)
+	assert.Equal(t, "61.0", con.ApiVersion)
+	assert.Equal(t, "https://dev.my.salesforce.com", con.BaseUrl)
+	assert.Equal(t, "00D000000000001", con.OrgId)
+
+	source, ok := con.TokenSource.(*RefreshTokenSource)
+	require.True(t, ok)
+	assert.Equal(t, refreshToken, source.RefreshToken)
+}
+
+func TestParseSfdxAuthUrl(t *testing.T) {
+	tests := []struct {
+		name    string
+		authUrl string
+		want    SfAuthInfo
+		wantErr bool
+	}{
+		{
+			name:    "Without client secret",
+			authUrl: "force://PlatformCLI::5Aep861token@dev.my.salesforce.com",
+			want: SfAuthInfo{
+				InstanceUrl:  "https://dev.my.salesforce.com",
+				ClientId:     "PlatformCLI",
+				RefreshToken: "5Aep861token",
+			},
+		},
+		{
+			name:    "With client secret",
+			authUrl: "force://client:secret:5Aep861token@https://dev.my.salesforce.com/",
+			want: SfAuthInfo{
+				InstanceUrl:  "https://dev.my.salesforce.com",
+				ClientId:     "client",
+				ClientSecret: "secret",
+				RefreshToken: "5Aep861token",
+			},
+		},
+		{
+			name:    "Missing refresh token",
+			authUrl: "force://PlatformCLI@dev.my.salesforce.com",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSfdxAuthUrl(tt.authUrl)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func encryptSfV1(t *testing.T, key, iv, plain string) string {
+	block, err := aes.NewCipher([]byte(key))
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+
+	sealed := gcm.Seal(nil, []byte(iv), []byte(plain), nil)
+	tagStart := len(sealed) - gcm.Overhead()
+
+	return iv + hex.EncodeToString(sealed[:tagStart]) + ":" + hex.EncodeToString(sealed[tagStart:])
+}
@@ -0,0 +1,282 @@
+// Package soql builds SOQL queries with literals escaped, so that values such
+// as names from a package.xml can't change the meaning of a query.
+//
+//	q := soql.Select("Id", "Name").
+//		From("ApexClass").
+//		Where(soql.In("Name", names)).
+//		OrderBy("Name").
+//		Tooling()
+//	path := q.Path("60.0") // /services/data/v60.0/tooling/query/?q=SELECT+Id%2CName+...
+package soql
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Endpoint is the API a query is sent to.
+type Endpoint int
+
+const (
+	REST Endpoint = iota
+	Tooling
+)
+
+// Path returns the URL path and encoded query string that runs query
+// against the endpoint.
+func (e Endpoint) Path(apiVersion, query string) string {
+	resource := "/query/"
+	if e == Tooling {
+		resource = "/tooling/query/"
+	}
+
+	return "/services/data/v" + apiVersion + resource + "?q=" + url.QueryEscape(query)
+}
+
+// Query is a SELECT statement. Its methods modify and return the same Query.
+type Query struct {
+	fields   []string
+	object   string
+	where    Condition
+	orderBy  []string
+	limit    int
+	endpoint Endpoint
+}
+
+func Select(fields ...string) *Query {
+	return &Query{fields: fields, limit: -1}
+}
+
+func (q *Query) From(object string) *Query {
+	q.object = object
+	return q
+}
+
+// Where sets the condition of the query, replacing a previous one.
+func (q *Query) Where(cond Condition) *Query {
+	q.where = cond
+	return q
+}
+
+func (q *Query) OrderBy(field string) *Query {
+	q.orderBy = append(q.orderBy, field)
+	return q
+}
+
+func (q *Query) OrderByDesc(field string) *Query {
+	q.orderBy = append(q.orderBy, field+" DESC")
+	return q
+}
+
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Tooling marks the query to be sent to the Tooling API.
+func (q *Query) Tooling() *Query {
+	q.endpoint = Tooling
+	return q
+}
+
+func (q *Query) Endpoint() Endpoint {
+	return q.endpoint
+}
+
+// Path returns the URL path of the query on its endpoint, see Endpoint.Path.
+func (q *Query) Path(apiVersion string) string {
+	return q.endpoint.Path(apiVersion, q.String())
+}
+
+func (q *Query) String() string {
+	var sb strings.Builder
+	sb.WriteString("SELECT " + strings.Join(q.fields, ", ") + " FROM " + q.object)
+
+	if q.where.expr != "" {
+		sb.WriteString(" WHERE " + q.where.expr)
+	}
+	if len(q.orderBy) > 0 {
+		sb.WriteString(" ORDER BY " + strings.Join(q.orderBy, ", "))
+	}
+	if q.limit >= 0 {
+		sb.WriteString(" LIMIT " + strconv.Itoa(q.limit))
+	}
+
+	return sb.String()
+}
+
+// Condition is an expression of a WHERE clause. The zero Condition matches
+// every record.
+type Condition struct {
+	expr string
+}
+
+func (c Condition) String() string {
+	return c.expr
+}
+
+func Eq(field string, value any) Condition { return compare(field, "=", value) }
+func Ne(field string, value any) Condition { return compare(field, "!=", value) }
+func Lt(field string, value any) Condition { return compare(field, "<", value) }
+func Le(field string, value any) Condition { return compare(field, "<=", value) }
+func Gt(field string, value any) Condition { return compare(field, ">", value) }
+func Ge(field string, value any) Condition { return compare(field, ">=", value) }
+
+func compare(field, op string, value any) Condition {
+	return Condition{field + " " + op + " " + Literal(value)}
+}
+
+// In matches records whose field is one of values. SOQL rejects an empty
+// list, so without values it's a condition no record matches.
+func In[T any](field string, values []T) Condition {
+	if len(values) == 0 {
+		return Condition{field + " = null AND " + field + " != null"}
+	}
+	return Condition{field + " IN (" + literalList(values) + ")"}
+}
+
+// NotIn matches records whose field is none of values. Without values it's a
+// condition every record matches.
+func NotIn[T any](field string, values []T) Condition {
+	if len(values) == 0 {
+		return Condition{field + " = null OR " + field + " != null"}
+	}
+	return Condition{field + " NOT IN (" + literalList(values) + ")"}
+}
+
+// Like matches field against pattern, in which % and _ are wildcards.
+// Use EscapeLike for parts of the pattern that must match literally.
+func Like(field, pattern string) Condition {
+	return Condition{field + " LIKE " + quoteLike(pattern)}
+}
+
+func And(conds ...Condition) Condition { return join(" AND ", conds) }
+func Or(conds ...Condition) Condition  { return join(" OR ", conds) }
+
+// Not negates cond. The zero Condition matches every record, so its negation
+// is one that no record matches, as every record has an Id.
+func Not(cond Condition) Condition {
+	if cond.expr == "" {
+		return Condition{"Id = null"}
+	}
+	return Condition{"NOT (" + cond.expr + ")"}
+}
+
+func join(op string, conds []Condition) Condition {
+	exprs := make([]string, 0, len(conds))
+	for _, c := range conds {
+		if c.expr != "" {
+			exprs = append(exprs, c.expr)
+		}
+	}
+
+	if len(exprs) == 1 {
+		return Condition{exprs[0]}
+	}
+
+	for i, expr := range exprs {
+		exprs[i] = "(" + expr + ")"
+	}
+
+	return Condition{strings.Join(exprs, op)}
+}
+
+// DateLiteral is a relative date such as TODAY or LAST_N_DAYS:7, written
+// into a query without quotes.
+type DateLiteral string
+
+const (
+	Today     DateLiteral = "TODAY"
+	Yesterday DateLiteral = "YESTERDAY"
+	Tomorrow  DateLiteral = "TOMORROW"
+	ThisWeek  DateLiteral = "THIS_WEEK"
+	LastWeek  DateLiteral = "LAST_WEEK"
+	ThisMonth DateLiteral = "THIS_MONTH"
+	LastMonth DateLiteral = "LAST_MONTH"
+)
+
+func LastNDays(n int) DateLiteral { return DateLiteral("LAST_N_DAYS:" + strconv.Itoa(n)) }
+func NextNDays(n int) DateLiteral { return DateLiteral("NEXT_N_DAYS:" + strconv.Itoa(n)) }
+
+// Date is a calendar date, written as YYYY-MM-DD for Date fields.
+// time.Time values are written as dateTime literals in UTC.
+type Date time.Time
+
+// Literal formats value as a SOQL literal: strings are quoted and escaped,
+// nil is null and times are dateTime literals.
+func Literal(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return Quote(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format("2006-01-02T15:04:05Z")
+	case Date:
+		return time.Time(v).Format(time.DateOnly)
+	case DateLiteral:
+		return string(v)
+	case fmt.Stringer:
+		return Quote(v.String())
+	default:
+		return Quote(fmt.Sprint(v))
+	}
+}
+
+func literalList[T any](values []T) string {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = Literal(v)
+	}
+
+	return strings.Join(literals, ",")
+}
+
+var quoteReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"\b", `\b`,
+	"\f", `\f`,
+)
+
+// Quote returns s as a single-quoted string literal with the escape
+// sequences SOQL requires.
+func Quote(s string) string {
+	return "'" + quoteReplacer.Replace(s) + "'"
+}
+
+// EscapeLike escapes the LIKE wildcards % and _ in s.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// quoteLike quotes a LIKE pattern, keeping the escape sequences that
+// EscapeLike produces.
+func quoteLike(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) && strings.IndexByte(`\%_`, pattern[i+1]) != -1 {
+			sb.WriteString(pattern[i : i+2])
+			i++
+			continue
+		}
+		sb.WriteString(quoteReplacer.Replace(pattern[i : i+1]))
+	}
+
+	return "'" + sb.String() + "'"
+}
@@ -0,0 +1,85 @@
+package soql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query *Query
+		want  string
+	}{
+		{
+			name:  "Fields only",
+			query: Select("Id", "Name").From("Account"),
+			want:  "SELECT Id, Name FROM Account",
+		},
+		{
+			name: "Full",
+			query: Select("Id").
+				From("ApexClass").
+				Where(And(In("Name", []string{"A", "B"}), Not(Like("Name", "%"+EscapeLike("_Test"))))).
+				OrderBy("Name").
+				OrderByDesc("CreatedDate").
+				Limit(10),
+			want: `SELECT Id FROM ApexClass WHERE (Name IN ('A','B')) AND (NOT (Name LIKE '%\_Test')) ORDER BY Name, CreatedDate DESC LIMIT 10`,
+		},
+		{
+			name:  "Single condition",
+			query: Select("Id").From("Account").Where(Or(Eq("Name", "Acme"), Condition{})),
+			want:  "SELECT Id FROM Account WHERE Name = 'Acme'",
+		},
+		{
+			name: "Empty lists",
+			query: Select("Id").From("ApexClass").Where(Or(
+				In("Name", []string{}),
+				And(NotIn[string]("Name", nil), Eq("Status", "Active")),
+			)),
+			want: "SELECT Id FROM ApexClass WHERE (Name = null AND Name != null)" +
+				" OR ((Name = null OR Name != null) AND (Status = 'Active'))",
+		},
+		{
+			name:  "Not of the zero condition",
+			query: Select("Id").From("ApexClass").Where(Or(Not(Condition{}), Not(And()))),
+			want:  "SELECT Id FROM ApexClass WHERE (Id = null) OR (Id = null)",
+		},
+		{
+			name: "Literals",
+			query: Select("Id").From("ApexTestResult").Where(And(
+				Eq("IsDeleted", false),
+				Ge("RunTime", 100),
+				Gt("SystemModstamp", time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))),
+				Lt("TestTimestamp", LastNDays(7)),
+				Ne("ApexLogId", nil),
+				Le("CreatedDate", Date(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))),
+			)),
+			want: "SELECT Id FROM ApexTestResult WHERE (IsDeleted = false) AND (RunTime >= 100)" +
+				" AND (SystemModstamp > 2024-01-02T02:04:05Z) AND (TestTimestamp < LAST_N_DAYS:7)" +
+				" AND (ApexLogId != null) AND (CreatedDate <= 2024-01-02)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.query.String())
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	assert.Equal(t, `'O\'Brien'`, Quote("O'Brien"))
+	assert.Equal(t, `'a\\b\nc\"d'`, Quote("a\\b\nc\"d"))
+	assert.Equal(t, `'x\') OR Name != (\'y'`, Quote("x') OR Name != ('y"))
+}
+
+func TestPath(t *testing.T) {
+	q := Select("Id").From("ApexClass").Where(Eq("Name", "A&B"))
+
+	assert.Equal(t, "/services/data/v60.0/query/?q=SELECT+Id+FROM+ApexClass+WHERE+Name+%3D+%27A%26B%27", q.Path("60.0"))
+	assert.Equal(t, Tooling, q.Tooling().Endpoint())
+	assert.Equal(t, "/services/data/v60.0/tooling/query/?q=SELECT+Id+FROM+ApexClass+WHERE+Name+%3D+%27A%26B%27", q.Path("60.0"))
+}
@@ -0,0 +1,136 @@
+package sfapi
+
+import (
+	"slices"
+	"strings"
+)
+
+// ApexClass_SymbolTable is the SymbolTable field of ApexClass and ApexTrigger,
+// the compiler's outline of the declarations in the Apex and the Apex it
+// references. Inner classes have their own SymbolTable.
+type ApexClass_SymbolTable struct {
+	Name               string                          `json:"name"`
+	Namespace          string                          `json:"namespace"`
+	Key                string                          `json:"key"`
+	TableDeclaration   SymbolTable_Symbol              `json:"tableDeclaration"`
+	ParentClass        string                          `json:"parentClass"`
+	Interfaces         []string                        `json:"interfaces"`
+	Constructors       []SymbolTable_Method            `json:"constructors"`
+	Methods            []SymbolTable_Method            `json:"methods"`
+	Properties         []SymbolTable_Property          `json:"properties"`
+	Variables          []SymbolTable_Symbol            `json:"variables"`
+	InnerClasses       []ApexClass_SymbolTable         `json:"innerClasses"`
+	ExternalReferences []SymbolTable_ExternalReference `json:"externalReferences"`
+}
+
+type SymbolTable_Symbol struct {
+	Name        string                   `json:"name"`
+	Type        string                   `json:"type"`
+	Location    SymbolTable_Position     `json:"location"`
+	Modifiers   []string                 `json:"modifiers"`
+	Annotations []SymbolTable_Annotation `json:"annotations"`
+	References  []SymbolTable_Position   `json:"references"`
+}
+
+type SymbolTable_Annotation struct {
+	Name string `json:"name"`
+}
+
+type SymbolTable_Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type SymbolTable_Method struct {
+	SymbolTable_Symbol
+	Visibility string                  `json:"visibility"`
+	ReturnType string                  `json:"returnType"`
+	Parameters []SymbolTable_Parameter `json:"parameters"`
+}
+
+type SymbolTable_Parameter struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type SymbolTable_Property struct {
+	SymbolTable_Symbol
+	Visibility string `json:"visibility"`
+}
+
+// SymbolTable_ExternalReference is a class the Apex refers to along with the
+// methods and variables of it that it uses.
+type SymbolTable_ExternalReference struct {
+	Name       string                       `json:"name"`
+	Namespace  string                       `json:"namespace"`
+	References []SymbolTable_Position       `json:"references"`
+	Methods    []SymbolTable_ExternalMethod `json:"methods"`
+	Variables  []SymbolTable_ExternalSymbol `json:"variables"`
+}
+
+type SymbolTable_ExternalMethod struct {
+	Name       string                 `json:"name"`
+	IsStatic   bool                   `json:"isStatic"`
+	ReturnType string                 `json:"returnType"`
+	ArgTypes   []string               `json:"argTypes"`
+	References []SymbolTable_Position `json:"references"`
+}
+
+type SymbolTable_ExternalSymbol struct {
+	Name       string                 `json:"name"`
+	References []SymbolTable_Position `json:"references"`
+}
+
+// IsTest reports whether the symbol is annotated with @IsTest or declared
+// with the legacy testMethod modifier.
+func (s SymbolTable_Symbol) IsTest() bool {
+	return slices.ContainsFunc(s.Annotations, func(a SymbolTable_Annotation) bool {
+		return strings.EqualFold(a.Name, "IsTest")
+	}) || slices.ContainsFunc(s.Modifiers, func(m string) bool {
+		return strings.EqualFold(m, "testMethod")
+	})
+}
+
+// IsTest reports whether the class is a test class or contains test methods,
+// also in its inner classes.
+func (st ApexClass_SymbolTable) IsTest() bool {
+	return st.TableDeclaration.IsTest() ||
+		slices.ContainsFunc(st.Methods, func(m SymbolTable_Method) bool { return m.IsTest() }) ||
+		slices.ContainsFunc(st.InnerClasses, ApexClass_SymbolTable.IsTest)
+}
+
+// TestMethods returns the names of the test methods of the class. Those of
+// inner classes are qualified with the inner class name.
+func (st ApexClass_SymbolTable) TestMethods() []string {
+	res := make([]string, 0)
+	for _, m := range st.Methods {
+		if m.IsTest() {
+			res = append(res, m.Name)
+		}
+	}
+
+	for _, inner := range st.InnerClasses {
+		for _, m := range inner.TestMethods() {
+			res = append(res, inner.Name+"."+m)
+		}
+	}
+
+	return res
+}
+
+// ReferencedClasses returns the names of the classes referenced by the class
+// and its inner classes, namespaced ones as ns__Name, sorted and without
+// duplicates.
+func (st ApexClass_SymbolTable) ReferencedClasses() []string {
+	res := make([]string, 0, len(st.ExternalReferences))
+	for _, ref := range st.ExternalReferences {
+		res = append(res, QualifiedName(ref.Namespace, ref.Name))
+	}
+
+	for _, inner := range st.InnerClasses {
+		res = append(res, inner.ReferencedClasses()...)
+	}
+
+	slices.Sort(res)
+	return slices.Compact(res)
+}
@@ -0,0 +1,98 @@
+package sfapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const accountServiceTestSymbolTable = `{
+	"name": "AccountService_Test",
+	"namespace": null,
+	"key": "AccountService_Test",
+	"tableDeclaration": {
+		"name": "AccountService_Test",
+		"type": "AccountService_Test",
+		"location": {"line": 2, "column": 14},
+		"modifiers": ["private"],
+		"annotations": [{"name": "IsTest"}],
+		"references": []
+	},
+	"parentClass": "",
+	"interfaces": [],
+	"constructors": [],
+	"methods": [
+		{
+			"name": "createsAccount",
+			"type": null,
+			"location": {"line": 4, "column": 17},
+			"modifiers": ["private", "static"],
+			"annotations": [{"name": "IsTest"}],
+			"references": [],
+			"visibility": "PRIVATE",
+			"returnType": "void",
+			"parameters": []
+		},
+		{
+			"name": "setup",
+			"location": {"line": 9, "column": 17},
+			"modifiers": ["private", "static"],
+			"annotations": [{"name": "TestSetup"}],
+			"returnType": "void",
+			"parameters": []
+		}
+	],
+	"properties": [],
+	"variables": [],
+	"innerClasses": [
+		{
+			"name": "Legacy",
+			"tableDeclaration": {"name": "Legacy", "modifiers": ["private"], "annotations": []},
+			"methods": [
+				{"name": "oldStyle", "modifiers": ["static", "testMethod"], "annotations": [], "parameters": []}
+			],
+			"externalReferences": [
+				{"name": "AccountSelector", "namespace": "", "references": [{"line": 20, "column": 9}], "methods": [], "variables": []}
+			]
+		}
+	],
+	"externalReferences": [
+		{
+			"name": "AccountService",
+			"namespace": "",
+			"references": [{"line": 5, "column": 9}],
+			"methods": [
+				{"name": "create", "isStatic": true, "returnType": "Account", "argTypes": ["String"], "references": [{"line": 5, "column": 24}]}
+			],
+			"variables": []
+		},
+		{"name": "Logger", "namespace": "nebula", "references": [], "methods": [], "variables": []},
+		{"name": "AccountSelector", "namespace": "", "references": [], "methods": [], "variables": []}
+	]
+}`
+
+func TestSymbolTable(t *testing.T) {
+	var st ApexClass_SymbolTable
+	require.NoError(t, json.Unmarshal([]byte(accountServiceTestSymbolTable), &st))
+
+	assert.Equal(t, SymbolTable_Position{Line: 2, Column: 14}, st.TableDeclaration.Location)
+	assert.Equal(t, "void", st.Methods[0].ReturnType)
+	assert.Equal(t, []string{"private", "static"}, st.Methods[0].Modifiers)
+	assert.Equal(t, []string{"String"}, st.ExternalReferences[0].Methods[0].ArgTypes)
+
+	assert.True(t, st.IsTest())
+	assert.Equal(t, []string{"createsAccount", "Legacy.oldStyle"}, st.TestMethods())
+	assert.Equal(t, []string{"AccountSelector", "AccountService", "nebula__Logger"}, st.ReferencedClasses())
+
+	t.Run("Inner test class", func(t *testing.T) {
+		outer := ApexClass_SymbolTable{
+			InnerClasses: []ApexClass_SymbolTable{{
+				TableDeclaration: SymbolTable_Symbol{Annotations: []SymbolTable_Annotation{{Name: "isTest"}}},
+			}},
+		}
+		assert.True(t, outer.IsTest())
+		assert.False(t, ApexClass_SymbolTable{}.IsTest())
+	})
+}
@@ -0,0 +1,347 @@
+package sfapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/achere/g-force/pkg/sfapi/soql"
+)
+
+const (
+	TestLevelRunSpecifiedTests = "RunSpecifiedTests"
+	TestLevelRunLocalTests     = "RunLocalTests"
+	TestLevelRunAllTestsInOrg  = "RunAllTestsInOrg"
+)
+
+// Statuses of ApexTestRunResult and ApexTestQueueItem.
+const (
+	TestStatusQueued     = "Queued"
+	TestStatusProcessing = "Processing"
+	TestStatusCompleted  = "Completed"
+	TestStatusFailed     = "Failed"
+	TestStatusAborted    = "Aborted"
+)
+
+// Outcomes of ApexTestResult.
+const (
+	TestOutcomePass        = "Pass"
+	TestOutcomeFail        = "Fail"
+	TestOutcomeCompileFail = "CompileFail"
+	TestOutcomeSkip        = "Skip"
+)
+
+// defaultTestPollInterval is how often WaitForTestRun checks on a test run
+// if TestRunOptions.PollInterval isn't set.
+const defaultTestPollInterval = 5 * time.Second
+
+// maxEmptyTestRunPolls is how many polls in a row WaitForTestRun accepts
+// without a trace of the test run, as a job that was just enqueued may take
+// a moment to show up, before it gives up on a wrong or purged job id.
+const maxEmptyTestRunPolls = 12
+
+// RunTestsRequest selects the tests to run. Tests can't be combined with
+// SuiteNames, ClassNames are added to Tests if both are set.
+type RunTestsRequest struct {
+	ClassNames       []string
+	SuiteNames       []string
+	Tests            []RunTestsRequest_Test
+	TestLevel        string
+	SkipCodeCoverage bool
+}
+
+// RunTestsRequest_Test selects methods of a test class, or all of them if
+// TestMethods is empty.
+type RunTestsRequest_Test struct {
+	ClassName   string   `json:"className"`
+	TestMethods []string `json:"testMethods,omitempty"`
+}
+
+type RunTestsResult struct {
+	NumTestsRun int                      `json:"numTestsRun"`
+	NumFailures int                      `json:"numFailures"`
+	TotalTime   float64                  `json:"totalTime"`
+	Successes   []RunTestsResult_Success `json:"successes"`
+	Failures    []RunTestsResult_Failure `json:"failures"`
+	ApexLogId   string                   `json:"apexLogId"`
+}
+
+type RunTestsResult_Success struct {
+	Id         string  `json:"id"`
+	Name       string  `json:"name"`
+	Namespace  string  `json:"namespace"`
+	MethodName string  `json:"methodName"`
+	Time       float64 `json:"time"`
+}
+
+type RunTestsResult_Failure struct {
+	Id         string  `json:"id"`
+	Name       string  `json:"name"`
+	Namespace  string  `json:"namespace"`
+	MethodName string  `json:"methodName"`
+	Message    string  `json:"message"`
+	StackTrace string  `json:"stackTrace"`
+	Type       string  `json:"type"`
+	Time       float64 `json:"time"`
+}
+
+type ApexTestRunResult struct {
+	Id               string `json:"Id"`
+	AsyncApexJobId   string `json:"AsyncApexJobId"`
+	Status           string `json:"Status"`
+	ClassesCompleted int    `json:"ClassesCompleted"`
+	ClassesEnqueued  int    `json:"ClassesEnqueued"`
+	MethodsCompleted int    `json:"MethodsCompleted"`
+	MethodsEnqueued  int    `json:"MethodsEnqueued"`
+	MethodsFailed    int    `json:"MethodsFailed"`
+	StartTime        string `json:"StartTime"`
+	EndTime          string `json:"EndTime"`
+	TestTime         int    `json:"TestTime"`
+}
+
+type ApexTestQueueItem struct {
+	Id             string                   `json:"Id"`
+	ApexClass      ApexTestResult_ApexClass `json:"ApexClass"`
+	Status         string                   `json:"Status"`
+	ExtendedStatus string                   `json:"ExtendedStatus"`
+	ParentJobId    string                   `json:"ParentJobId"`
+}
+
+type ApexTestResult struct {
+	Id             string                   `json:"Id"`
+	ApexClass      ApexTestResult_ApexClass `json:"ApexClass"`
+	MethodName     string                   `json:"MethodName"`
+	Outcome        string                   `json:"Outcome"`
+	Message        string                   `json:"Message"`
+	StackTrace     string                   `json:"StackTrace"`
+	RunTime        int                      `json:"RunTime"`
+	ApexLogId      string                   `json:"ApexLogId"`
+	TestTimestamp  string                   `json:"TestTimestamp"`
+	AsyncApexJobId string                   `json:"AsyncApexJobId"`
+}
+
+type ApexTestResult_ApexClass struct {
+	Id   string `json:"Id"`
+	Name string `json:"Name"`
+}
+
+// TestRunProgress is the state of a test run as of the latest poll.
+// RunResult is nil until Salesforce has created the ApexTestRunResult.
+type TestRunProgress struct {
+	RunResult  *ApexTestRunResult
+	QueueItems []ApexTestQueueItem
+}
+
+// Done reports whether all test classes have finished running.
+func (p TestRunProgress) Done() bool {
+	if p.RunResult != nil {
+		return isTestRunFinished(p.RunResult.Status)
+	}
+
+	return len(p.QueueItems) > 0 && !slices.ContainsFunc(p.QueueItems, func(item ApexTestQueueItem) bool {
+		return !isTestRunFinished(item.Status)
+	})
+}
+
+type TestRunOptions struct {
+	// PollInterval defaults to 5 seconds.
+	PollInterval time.Duration
+	// OnProgress is called after every poll.
+	OnProgress func(TestRunProgress)
+}
+
+// RunTestsAsynchronous enqueues tests and returns the id of the AsyncApexJob
+// running them, see WaitForTestRun.
+func (c *Connection) RunTestsAsynchronous(ctx context.Context, request RunTestsRequest) (string, error) {
+	respBody, err := c.postRunTests(ctx, "runTestsAsynchronous", request)
+	if err != nil {
+		return "", err
+	}
+
+	var jobId string
+	if err := json.Unmarshal(respBody, &jobId); err != nil {
+		return "", fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return jobId, nil
+}
+
+// RunTestsSynchronous runs tests and waits for the results in the same request,
+// which Salesforce only allows for a single test class.
+func (c *Connection) RunTestsSynchronous(ctx context.Context, request RunTestsRequest) (RunTestsResult, error) {
+	respBody, err := c.postRunTests(ctx, "runTestsSynchronous", request)
+	if err != nil {
+		return RunTestsResult{}, err
+	}
+
+	var result RunTestsResult
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return RunTestsResult{}, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return result, nil
+}
+
+// RunTests runs tests asynchronously and waits for their results.
+func (c *Connection) RunTests(ctx context.Context, request RunTestsRequest, opts TestRunOptions) ([]ApexTestResult, error) {
+	jobId, err := c.RunTestsAsynchronous(ctx, request)
+	if err != nil {
+		return []ApexTestResult{}, fmt.Errorf("c.RunTestsAsynchronous: %w", err)
+	}
+
+	return c.WaitForTestRun(ctx, jobId, opts)
+}
+
+// WaitForTestRun polls the test run of jobId until all of its classes have
+// finished and returns the results of every test method. It fails if the
+// test run can't be found in several polls in a row.
+func (c *Connection) WaitForTestRun(ctx context.Context, jobId string, opts TestRunOptions) ([]ApexTestResult, error) {
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = defaultTestPollInterval
+	}
+
+	var emptyPolls int
+	for {
+		progress, err := c.requestTestRunProgress(ctx, jobId)
+		if err != nil {
+			return []ApexTestResult{}, err
+		}
+
+		if progress.RunResult == nil && len(progress.QueueItems) == 0 {
+			emptyPolls++
+			if emptyPolls >= maxEmptyTestRunPolls {
+				return []ApexTestResult{}, errors.New("no test run found for job " + jobId)
+			}
+		} else {
+			emptyPolls = 0
+		}
+
+		if opts.OnProgress != nil {
+			opts.OnProgress(progress)
+		}
+
+		if progress.Done() {
+			return c.RequestTestResults(ctx, jobId)
+		}
+
+		select {
+		case <-ctx.Done():
+			return []ApexTestResult{}, fmt.Errorf("context canceled: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (c *Connection) RequestTestRunResult(ctx context.Context, jobId string) (*ApexTestRunResult, error) {
+	query := soql.Select(
+		"Id", "AsyncApexJobId", "Status", "ClassesCompleted", "ClassesEnqueued",
+		"MethodsCompleted", "MethodsEnqueued", "MethodsFailed", "StartTime", "EndTime", "TestTime",
+	).
+		From("ApexTestRunResult").
+		Where(soql.Eq("AsyncApexJobId", jobId)).
+		Tooling()
+
+	records, err := queryAll[ApexTestRunResult](c, ctx, query.Path(c.ApiVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	return &records[0], nil
+}
+
+func (c *Connection) RequestTestQueueItems(ctx context.Context, jobId string) ([]ApexTestQueueItem, error) {
+	query := soql.Select("Id", "ApexClass.Id", "ApexClass.Name", "Status", "ExtendedStatus", "ParentJobId").
+		From("ApexTestQueueItem").
+		Where(soql.Eq("ParentJobId", jobId)).
+		Tooling()
+
+	return queryAll[ApexTestQueueItem](c, ctx, query.Path(c.ApiVersion))
+}
+
+func (c *Connection) RequestTestResults(ctx context.Context, jobId string) ([]ApexTestResult, error) {
+	query := soql.Select(
+		"Id", "ApexClass.Id", "ApexClass.Name", "MethodName", "Outcome", "Message", "StackTrace",
+		"RunTime", "ApexLogId", "TestTimestamp", "AsyncApexJobId",
+	).
+		From("ApexTestResult").
+		Where(soql.Eq("AsyncApexJobId", jobId)).
+		OrderBy("ApexClass.Name").
+		OrderBy("MethodName").
+		Tooling()
+
+	return queryAll[ApexTestResult](c, ctx, query.Path(c.ApiVersion))
+}
+
+func (c *Connection) requestTestRunProgress(ctx context.Context, jobId string) (TestRunProgress, error) {
+	runResult, err := c.RequestTestRunResult(ctx, jobId)
+	if err != nil {
+		return TestRunProgress{}, fmt.Errorf("c.RequestTestRunResult: %w", err)
+	}
+
+	queueItems, err := c.RequestTestQueueItems(ctx, jobId)
+	if err != nil {
+		return TestRunProgress{}, fmt.Errorf("c.RequestTestQueueItems: %w", err)
+	}
+
+	return TestRunProgress{RunResult: runResult, QueueItems: queueItems}, nil
+}
+
+func (c *Connection) postRunTests(ctx context.Context, resource string, request RunTestsRequest) ([]byte, error) {
+	if len(request.SuiteNames) > 0 && len(request.Tests) > 0 {
+		return nil, errors.New("tests can't be combined with suites in a single run")
+	}
+
+	body := struct {
+		ClassNames       string                 `json:"classNames,omitempty"`
+		SuiteNames       string                 `json:"suiteNames,omitempty"`
+		Tests            []RunTestsRequest_Test `json:"tests,omitempty"`
+		TestLevel        string                 `json:"testLevel,omitempty"`
+		SkipCodeCoverage bool                   `json:"skipCodeCoverage,omitempty"`
+	}{
+		SuiteNames:       strings.Join(request.SuiteNames, ","),
+		Tests:            slices.Clone(request.Tests),
+		TestLevel:        request.TestLevel,
+		SkipCodeCoverage: request.SkipCodeCoverage,
+	}
+	if len(request.Tests) > 0 {
+		for _, name := range request.ClassNames {
+			body.Tests = append(body.Tests, RunTestsRequest_Test{ClassName: name})
+		}
+	} else {
+		body.ClassNames = strings.Join(request.ClassNames, ",")
+	}
+
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	url := c.BaseUrl + "/services/data/v" + c.ApiVersion + "/tooling/" + resource + "/"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	respBody, err := c.DoRequest(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("c.DoRequest: %w", err)
+	}
+
+	return respBody, nil
+}
+
+func isTestRunFinished(status string) bool {
+	return status == TestStatusCompleted || status == TestStatusFailed || status == TestStatusAborted
+}
@@ -0,0 +1,178 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForTestRun(t *testing.T) {
+	const jobId = "707000000000001"
+
+	var polls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/oauth2/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "token"})
+			return
+		}
+
+		q := r.URL.Query().Get("q")
+		assert.Contains(t, q, "'"+jobId+"'")
+
+		switch {
+		case strings.Contains(q, "FROM ApexTestRunResult"):
+			status := TestStatusProcessing
+			if polls.Add(1) > 1 {
+				status = TestStatusCompleted
+			}
+			json.NewEncoder(w).Encode(QueryPage[ApexTestRunResult]{
+				Done:    true,
+				Records: []ApexTestRunResult{{Status: status}},
+			})
+		case strings.Contains(q, "FROM ApexTestQueueItem"):
+			json.NewEncoder(w).Encode(QueryPage[ApexTestQueueItem]{Done: true})
+		case strings.Contains(q, "FROM ApexTestResult"):
+			json.NewEncoder(w).Encode(QueryPage[ApexTestResult]{
+				Done:    true,
+				Records: []ApexTestResult{{MethodName: "test", Outcome: TestOutcomePass}},
+			})
+		default:
+			t.Errorf("unexpected query: %s", q)
+		}
+	}))
+	defer mockServer.Close()
+
+	c := &Connection{
+		BaseUrl:    mockServer.URL,
+		ApiVersion: "60.0",
+		HttpClient: mockServer.Client(),
+	}
+
+	t.Run("Completed", func(t *testing.T) {
+		var statuses []string
+		results, err := c.WaitForTestRun(context.Background(), jobId, TestRunOptions{
+			PollInterval: time.Millisecond,
+			OnProgress: func(p TestRunProgress) {
+				statuses = append(statuses, p.RunResult.Status)
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{TestStatusProcessing, TestStatusCompleted}, statuses)
+		assert.Equal(t, []ApexTestResult{{MethodName: "test", Outcome: TestOutcomePass}}, results)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		polls.Store(-100)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := c.WaitForTestRun(ctx, jobId, TestRunOptions{PollInterval: time.Hour})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestWaitForTestRunUnknownJob(t *testing.T) {
+	var polls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/oauth2/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "token"})
+			return
+		}
+
+		if strings.Contains(r.URL.Query().Get("q"), "FROM ApexTestRunResult") {
+			polls.Add(1)
+		}
+		json.NewEncoder(w).Encode(QueryPage[ApexTestQueueItem]{Done: true})
+	}))
+	defer mockServer.Close()
+
+	c := &Connection{
+		BaseUrl:    mockServer.URL,
+		ApiVersion: "60.0",
+		HttpClient: mockServer.Client(),
+	}
+
+	_, err := c.WaitForTestRun(context.Background(), "707000000000002", TestRunOptions{PollInterval: time.Millisecond})
+	assert.EqualError(t, err, "no test run found for job 707000000000002")
+	assert.Equal(t, int32(maxEmptyTestRunPolls), polls.Load())
+}
+
+func TestTestRunProgressDone(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress TestRunProgress
+		want     bool
+	}{
+		{
+			name: "Not started",
+		},
+		{
+			name:     "Run result processing",
+			progress: TestRunProgress{RunResult: &ApexTestRunResult{Status: TestStatusProcessing}},
+		},
+		{
+			name:     "Run result aborted",
+			progress: TestRunProgress{RunResult: &ApexTestRunResult{Status: TestStatusAborted}},
+			want:     true,
+		},
+		{
+			name: "Queue items pending",
+			progress: TestRunProgress{QueueItems: []ApexTestQueueItem{
+				{Status: TestStatusCompleted}, {Status: TestStatusQueued},
+			}},
+		},
+		{
+			name: "Queue items finished",
+			progress: TestRunProgress{QueueItems: []ApexTestQueueItem{
+				{Status: TestStatusCompleted}, {Status: TestStatusFailed},
+			}},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.progress.Done())
+		})
+	}
+}
+
+func TestRunTestsAsynchronousKeepsRequest(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/oauth2/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "token"})
+			return
+		}
+
+		var body struct {
+			Tests []RunTestsRequest_Test `json:"tests"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, []RunTestsRequest_Test{{ClassName: "A_Test"}, {ClassName: "B_Test"}}, body.Tests)
+		json.NewEncoder(w).Encode("707000000000001")
+	}))
+	defer mockServer.Close()
+
+	c := &Connection{
+		BaseUrl:    mockServer.URL,
+		ApiVersion: "60.0",
+		HttpClient: mockServer.Client(),
+	}
+
+	tests := make([]RunTestsRequest_Test, 1, 2)
+	tests[0] = RunTestsRequest_Test{ClassName: "A_Test"}
+	_, err := c.RunTestsAsynchronous(context.Background(), RunTestsRequest{
+		ClassNames: []string{"B_Test"},
+		Tests:      tests,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, RunTestsRequest_Test{}, tests[:2][1], "the caller's array must not be written to")
+}
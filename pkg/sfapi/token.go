@@ -0,0 +1,154 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiryDelta is subtracted from a token's expiry so that it's refreshed
+// before Salesforce starts rejecting it mid-request.
+const expiryDelta = 30 * time.Second
+
+// Token is an access token together with the instance it was issued for.
+// A zero Expiry means the lifetime of the token is unknown and it's used
+// until Salesforce rejects it. IdentityUrl is only known for tokens obtained
+// from the token endpoint.
+type Token struct {
+	AccessToken string
+	InstanceUrl string
+	IdentityUrl string
+	IssuedAt    time.Time
+	Expiry      time.Time
+}
+
+func (t *Token) Valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+
+	return t.Expiry.IsZero() || time.Now().Before(t.Expiry.Add(-expiryDelta))
+}
+
+// TokenSource supplies access tokens to a Connection. Token is called whenever
+// the Connection has no valid token, including after Salesforce rejected
+// the previous one.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// ClientCredentialsSource obtains tokens with the OAuth 2.0 Client Credentials Flow.
+type ClientCredentialsSource struct {
+	BaseUrl      string
+	ClientId     string
+	ClientSecret string
+	HttpClient   *http.Client
+}
+
+func (s *ClientCredentialsSource) Token(ctx context.Context) (*Token, error) {
+	formData := url.Values{}
+	formData.Set("grant_type", "client_credentials")
+	formData.Set("client_id", s.ClientId)
+	formData.Set("client_secret", s.ClientSecret)
+
+	return requestToken(ctx, s.HttpClient, s.BaseUrl, formData)
+}
+
+// RefreshTokenSource exchanges a refresh token for access tokens.
+// ClientSecret can be left empty for Connected Apps that don't require it.
+type RefreshTokenSource struct {
+	BaseUrl      string
+	ClientId     string
+	ClientSecret string
+	RefreshToken string
+	HttpClient   *http.Client
+}
+
+func (s *RefreshTokenSource) Token(ctx context.Context) (*Token, error) {
+	formData := url.Values{}
+	formData.Set("grant_type", "refresh_token")
+	formData.Set("client_id", s.ClientId)
+	formData.Set("refresh_token", s.RefreshToken)
+	if s.ClientSecret != "" {
+		formData.Set("client_secret", s.ClientSecret)
+	}
+
+	return requestToken(ctx, s.HttpClient, s.BaseUrl, formData)
+}
+
+// StaticTokenSource always returns the same pre-minted access token.
+// Once it expires, the Connection fails as there is no way to get a new one.
+type StaticTokenSource struct {
+	AccessToken string
+	InstanceUrl string
+	Expiry      time.Time
+}
+
+func (s *StaticTokenSource) Token(ctx context.Context) (*Token, error) {
+	token := &Token{AccessToken: s.AccessToken, InstanceUrl: s.InstanceUrl, Expiry: s.Expiry}
+	if !token.Valid() {
+		return nil, errors.New("static access token is empty or expired")
+	}
+
+	return token, nil
+}
+
+func requestToken(ctx context.Context, httpClient *http.Client, baseUrl string, formData url.Values) (*Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("context canceled: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseUrl+"/services/oauth2/token", nil)
+	if err != nil {
+		return nil, fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Body = io.NopCloser(strings.NewReader(formData.Encode()))
+
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("httpClient.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp.StatusCode, RedactUrl(req.URL), respBody)
+	}
+
+	var tokenResp TokenResponse
+	err = json.Unmarshal(respBody, &tokenResp)
+	if err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	token := &Token{
+		AccessToken: tokenResp.AccessToken,
+		InstanceUrl: tokenResp.InstanceUrl,
+		IdentityUrl: tokenResp.Id,
+	}
+	if issuedAt, err := strconv.ParseInt(tokenResp.IssuedAt, 10, 64); err == nil {
+		token.IssuedAt = time.UnixMilli(issuedAt)
+	}
+	if tokenResp.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,83 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenSources(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/services/oauth2/token":
+			r.ParseForm()
+			if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "refreshed", InstanceUrl: "https://instance"})
+		default:
+			if r.Header.Get("Authorization") != "Bearer refreshed" && r.Header.Get("Authorization") != "Bearer static" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name      string
+		source    TokenSource
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name: "Refresh token",
+			source: &RefreshTokenSource{
+				BaseUrl:      mockServer.URL,
+				ClientId:     "client",
+				RefreshToken: "refresh",
+				HttpClient:   mockServer.Client(),
+			},
+			wantToken: "refreshed",
+		},
+		{
+			name:      "Static token",
+			source:    &StaticTokenSource{AccessToken: "static", Expiry: time.Now().Add(time.Hour)},
+			wantToken: "static",
+		},
+		{
+			name:    "Expired static token",
+			source:  &StaticTokenSource{AccessToken: "static", Expiry: time.Now().Add(-time.Hour)},
+			wantErr: true,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Connection{
+				BaseUrl:     mockServer.URL,
+				ApiVersion:  "60.0",
+				TokenSource: tt.source,
+				HttpClient:  mockServer.Client(),
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, mockServer.URL+"/services/data/v60.0/", nil)
+			_, err := c.DoRequest(ctx, req)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantToken, c.token.AccessToken)
+		})
+	}
+}
@@ -7,13 +7,20 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"strconv"
+	"slices"
 	"strings"
+
+	"github.com/achere/g-force/pkg/sfapi/soql"
+	"golang.org/x/sync/errgroup"
 )
 
-type toolingApiObject interface {
-	ApexCodeCoverage | MetadataComponentDependency | ApexClass
-}
+const (
+	// maxQueryLength keeps request URLs under the ~16k characters Salesforce
+	// accepts, leaving room for the instance URL.
+	maxQueryLength = 12000
+	// maxConcurrentQueries bounds the chunks of a single IN query in flight.
+	maxConcurrentQueries = 4
+)
 
 type ApexCodeCoverage struct {
 	ApexTestClass      ApexCodeCoverage_ApexTestClass      `json:"ApexTestClass"`
@@ -22,16 +29,26 @@ type ApexCodeCoverage struct {
 }
 
 type ApexCodeCoverage_ApexTestClass struct {
-	Name string `json:"Name"`
-	Id   string `json:"Id"`
+	Name            string `json:"Name"`
+	NamespacePrefix string `json:"NamespacePrefix"`
+	Id              string `json:"Id"`
+}
+
+func (c ApexCodeCoverage_ApexTestClass) QualifiedName() string {
+	return QualifiedName(c.NamespacePrefix, c.Name)
 }
 
 type ApexCodeCoverage_ApexClassOrTrigger struct {
 	Attributes struct {
 		Type string `json:"type"`
 	} `json:"attributes"`
-	Name string `json:"Name"`
-	Id   string `json:"Id"`
+	Name            string `json:"Name"`
+	NamespacePrefix string `json:"NamespacePrefix"`
+	Id              string `json:"Id"`
+}
+
+func (c ApexCodeCoverage_ApexClassOrTrigger) QualifiedName() string {
+	return QualifiedName(c.NamespacePrefix, c.Name)
 }
 
 type ApexCodeCoverage_Coverage struct {
@@ -39,118 +56,359 @@ type ApexCodeCoverage_Coverage struct {
 	UncoveredLines []int `json:"uncoveredLines"`
 }
 
+// ApexCodeCoverageAggregate is the coverage of a class or trigger by all tests
+// run since the last compilation.
+type ApexCodeCoverageAggregate struct {
+	ApexClassOrTrigger ApexCodeCoverage_ApexClassOrTrigger `json:"ApexClassOrTrigger"`
+	NumLinesCovered    int                                 `json:"NumLinesCovered"`
+	NumLinesUncovered  int                                 `json:"NumLinesUncovered"`
+}
+
+type ApexOrgWideCoverage struct {
+	PercentCovered int `json:"PercentCovered"`
+}
+
 type ApexClass struct {
-	Id          string                `json:"Id"`
-	Name        string                `json:"Name"`
-	IsValid     string                `json:"IsValid"`
-	Body        string                `json:"Body"`
-	SymbolTable ApexClass_SymbolTable `json:"SymbolTable"`
+	Id              string                `json:"Id"`
+	Name            string                `json:"Name"`
+	NamespacePrefix string                `json:"NamespacePrefix"`
+	IsValid         string                `json:"IsValid"`
+	Body            string                `json:"Body"`
+	SymbolTable     ApexClass_SymbolTable `json:"SymbolTable"`
 }
 
-type ApexClass_SymbolTable struct {
-	TableDeclaration struct {
-		Annotations []struct {
-			Name string `json:"name"`
-		} `json:"annotations"`
-		Modifiers []string `json:"modifiers"`
-	} `json:"tableDeclaration"`
+func (c ApexClass) QualifiedName() string {
+	return QualifiedName(c.NamespacePrefix, c.Name)
 }
 
-type MetadataComponentDependency struct {
-	Name    string `json:"MetadataComponentName"`
-	Id      string `json:"MetadataComponentId"`
-	Type    string `json:"MetadataComponentType"`
-	RefType string `json:"RefMetadataComponentType"`
-	RefName string `json:"RefMetadataComponentName"`
-	RefId   string `json:"RefMetadataComponentId"`
+type ApexTrigger struct {
+	Id              string                `json:"Id"`
+	Name            string                `json:"Name"`
+	NamespacePrefix string                `json:"NamespacePrefix"`
+	TableEnumOrId   string                `json:"TableEnumOrId"`
+	SymbolTable     ApexClass_SymbolTable `json:"SymbolTable"`
 }
 
-func (c *Connection) RequestCoverage(ctx context.Context, apexNames []string) ([]ApexCodeCoverage, error) {
-	query := "SELECT+ApexTestClass.Name,ApexTestClass.Id,ApexClassOrTrigger.Name,ApexClassOrTrigger.Id,Coverage+FROM+ApexCodeCoverage+WHERE+ApexClassOrTrigger.Name+IN+('"
-	query += strings.Join(apexNames, "','")
-	query += "')"
+func (t ApexTrigger) QualifiedName() string {
+	return QualifiedName(t.NamespacePrefix, t.Name)
+}
 
-	return queryToolingApi[ApexCodeCoverage](c, ctx, query)
+// QualifiedName returns name prefixed with namespace the way package.xml
+// refers to Apex of namespaced packages: ns__Name.
+func QualifiedName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "__" + name
 }
 
-func (c *Connection) RequestApexDependencies(ctx context.Context, metadataComponentTypes []string) ([]MetadataComponentDependency, error) {
-	query := "SELECT+MetadataComponentName,MetadataComponentId,MetadataComponentType,RefMetadataComponentType,RefMetadataComponentName,RefMetadataComponentId+FROM+MetadataComponentDependency+WHERE+RefMetadataComponentType+IN+('ApexClass','ApexTrigger')+AND+MetadataComponentType+IN+('"
-	query += strings.Join(metadataComponentTypes, "','")
-	query += "')"
+// SplitQualifiedName is the reverse of QualifiedName. Apex names can't
+// contain consecutive underscores, so the first "__" separates the namespace.
+func SplitQualifiedName(qualifiedName string) (namespace, name string) {
+	namespace, name, ok := strings.Cut(qualifiedName, "__")
+	if !ok {
+		return "", qualifiedName
+	}
+	return namespace, name
+}
 
-	return queryToolingApi[MetadataComponentDependency](c, ctx, query)
+// apexNameIn matches Apex by qualified names. Names without a namespace only
+// match Apex outside of namespaced packages or in orgNamespace, the org's own,
+// so that managed classes of the same name aren't mixed in. relationship is
+// the path to the Apex, e.g. "ApexClassOrTrigger.", or empty for the object
+// itself.
+func apexNameIn(relationship string, qualifiedNames []string, orgNamespace string) soql.Condition {
+	byNamespace := make(map[string][]string)
+	for _, qn := range qualifiedNames {
+		ns, name := SplitQualifiedName(qn)
+		byNamespace[ns] = append(byNamespace[ns], name)
+	}
+
+	namespaces := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	slices.Sort(namespaces)
+
+	conds := make([]soql.Condition, len(namespaces))
+	for i, ns := range namespaces {
+		var nsCond soql.Condition
+		switch {
+		case ns != "":
+			nsCond = soql.Eq(relationship+"NamespacePrefix", ns)
+		case orgNamespace != "":
+			nsCond = soql.In(relationship+"NamespacePrefix", []any{nil, orgNamespace})
+		default:
+			nsCond = soql.Eq(relationship+"NamespacePrefix", nil)
+		}
+		conds[i] = soql.And(soql.In(relationship+"Name", byNamespace[ns]), nsCond)
+	}
+
+	return soql.Or(conds...)
 }
 
-func (c *Connection) RequestApexClasses(ctx context.Context, names []string) ([]ApexClass, error) {
-	query := "SELECT+Id,Name,SymbolTable+FROM+ApexClass+WHERE+Name+IN+('"
-	query += strings.Join(names, "','")
-	query += "')"
+type MetadataComponentDependency struct {
+	Name         string `json:"MetadataComponentName"`
+	Namespace    string `json:"MetadataComponentNamespace"`
+	Id           string `json:"MetadataComponentId"`
+	Type         string `json:"MetadataComponentType"`
+	RefType      string `json:"RefMetadataComponentType"`
+	RefName      string `json:"RefMetadataComponentName"`
+	RefNamespace string `json:"RefMetadataComponentNamespace"`
+	RefId        string `json:"RefMetadataComponentId"`
+}
 
-	return queryToolingApi[ApexClass](c, ctx, query)
+// QualifiedName returns the name of the dependent component as ns__Name.
+func (d MetadataComponentDependency) QualifiedName() string {
+	return QualifiedName(d.Namespace, d.Name)
 }
 
-func (c *Connection) ExecuteAnonymousRest(ctx context.Context, body string) error {
-	strippedBody := url.QueryEscape(strings.Replace(body, "\n", " ", -1))
-	url := c.BaseUrl + "/services/data/v" + c.ApiVersion + "/tooling/executeAnonymous/?anonymousBody=" + strippedBody
+// RefQualifiedName returns the name of the referenced component as ns__Name.
+func (d MetadataComponentDependency) RefQualifiedName() string {
+	return QualifiedName(d.RefNamespace, d.RefName)
+}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func (c *Connection) RequestCoverage(ctx context.Context, apexNames []string) ([]ApexCodeCoverage, error) {
+	orgNs, err := c.OrgNamespace(ctx)
 	if err != nil {
-		return fmt.Errorf("http.NewRequest: %w", err)
+		return []ApexCodeCoverage{}, fmt.Errorf("c.OrgNamespace: %w", err)
 	}
 
-	respBody, err := c.DoRequest(ctx, req)
+	return queryToolingApiIn[ApexCodeCoverage](c, ctx, apexNames, func(names []string) *soql.Query {
+		return soql.Select(
+			"ApexTestClass.Name", "ApexTestClass.NamespacePrefix", "ApexTestClass.Id",
+			"ApexClassOrTrigger.Name", "ApexClassOrTrigger.NamespacePrefix", "ApexClassOrTrigger.Id", "Coverage",
+		).
+			From("ApexCodeCoverage").
+			Where(apexNameIn("ApexClassOrTrigger.", names, orgNs)).
+			Tooling()
+	})
+}
+
+func (c *Connection) RequestCoverageAggregate(ctx context.Context, apexNames []string) ([]ApexCodeCoverageAggregate, error) {
+	orgNs, err := c.OrgNamespace(ctx)
 	if err != nil {
-		return fmt.Errorf("c.makeRequest: %w", err)
+		return []ApexCodeCoverageAggregate{}, fmt.Errorf("c.OrgNamespace: %w", err)
 	}
 
-	var parsedResponse struct {
-		Line                int    `json:"line"`
-		Column              int    `json:"column"`
-		Compiled            bool   `json:"compiled"`
-		Success             bool   `json:"success"`
-		CompileProblem      string `json:"compileProblem"`
-		ExceptionStackTrace string `json:"exceptionStackTrace"`
-		ExceptionMessage    string `json:"exceptionMessage"`
-	}
+	return queryToolingApiIn[ApexCodeCoverageAggregate](c, ctx, apexNames, func(names []string) *soql.Query {
+		return soql.Select(
+			"ApexClassOrTrigger.Name", "ApexClassOrTrigger.NamespacePrefix", "ApexClassOrTrigger.Id",
+			"NumLinesCovered", "NumLinesUncovered",
+		).
+			From("ApexCodeCoverageAggregate").
+			Where(apexNameIn("ApexClassOrTrigger.", names, orgNs)).
+			Tooling()
+	})
+}
 
-	err = json.Unmarshal(respBody, &parsedResponse)
+func (c *Connection) RequestOrgWideCoverage(ctx context.Context) (ApexOrgWideCoverage, error) {
+	query := soql.Select("PercentCovered").From("ApexOrgWideCoverage").Tooling()
+
+	records, err := queryAll[ApexOrgWideCoverage](c, ctx, query.Path(c.ApiVersion))
 	if err != nil {
-		return fmt.Errorf("json.Unmarshal: %w", err)
+		return ApexOrgWideCoverage{}, err
 	}
 
-	if parsedResponse.Success {
-		return nil
+	if len(records) == 0 {
+		return ApexOrgWideCoverage{}, errors.New("no ApexOrgWideCoverage record returned")
 	}
 
-	if parsedResponse.Compiled {
-		msg := "error on line " +
-			strconv.Itoa(parsedResponse.Line) + ":" + strconv.Itoa(parsedResponse.Column) + " - " +
-			parsedResponse.ExceptionMessage + " " + parsedResponse.ExceptionStackTrace
-		return errors.New(msg)
-	}
+	return records[0], nil
+}
 
-	return errors.New("didn't compile: " + parsedResponse.CompileProblem)
+func (c *Connection) RequestApexDependencies(ctx context.Context, metadataComponentTypes []string) ([]MetadataComponentDependency, error) {
+	query := soql.Select(
+		"MetadataComponentName", "MetadataComponentNamespace", "MetadataComponentId", "MetadataComponentType",
+		"RefMetadataComponentType", "RefMetadataComponentName", "RefMetadataComponentNamespace", "RefMetadataComponentId",
+	).
+		From("MetadataComponentDependency").
+		Where(soql.And(
+			soql.In("RefMetadataComponentType", []string{"ApexClass", "ApexTrigger"}),
+			soql.In("MetadataComponentType", metadataComponentTypes),
+		)).
+		Tooling()
+
+	return queryAll[MetadataComponentDependency](c, ctx, query.Path(c.ApiVersion))
 }
 
-func queryToolingApi[T toolingApiObject](c *Connection, ctx context.Context, query string) ([]T, error) {
-	baseUrl := c.BaseUrl + "/services/data/v" + c.ApiVersion + "/tooling/query/?q="
-	req, err := http.NewRequest(http.MethodGet, baseUrl+query, nil)
+func (c *Connection) RequestApexClasses(ctx context.Context, names []string) ([]ApexClass, error) {
+	orgNs, err := c.OrgNamespace(ctx)
 	if err != nil {
-		return []T{}, fmt.Errorf("http.NewRequest: %w", err)
+		return []ApexClass{}, fmt.Errorf("c.OrgNamespace: %w", err)
 	}
 
-	respBody, err := c.DoRequest(ctx, req)
+	return queryToolingApiIn[ApexClass](c, ctx, names, func(names []string) *soql.Query {
+		return soql.Select("Id", "Name", "NamespacePrefix", "SymbolTable").
+			From("ApexClass").
+			Where(apexNameIn("", names, orgNs)).
+			Tooling()
+	})
+}
+
+// RequestApexTriggers returns the triggers with the given qualified names
+// along with their SymbolTable. TableEnumOrId is the object a trigger is on.
+func (c *Connection) RequestApexTriggers(ctx context.Context, names []string) ([]ApexTrigger, error) {
+	orgNs, err := c.OrgNamespace(ctx)
 	if err != nil {
-		return []T{}, fmt.Errorf("c.makeRequest: %w", err)
+		return []ApexTrigger{}, fmt.Errorf("c.OrgNamespace: %w", err)
 	}
 
-	var parsedResponse struct {
-		Records []T `json:"records"`
+	return queryToolingApiIn[ApexTrigger](c, ctx, names, func(names []string) *soql.Query {
+		return soql.Select("Id", "Name", "NamespacePrefix", "TableEnumOrId", "SymbolTable").
+			From("ApexTrigger").
+			Where(apexNameIn("", names, orgNs)).
+			Tooling()
+	})
+}
+
+// QueryPage is a single batch of query results. TotalSize is the number of
+// records matching the query across all pages.
+type QueryPage[T any] struct {
+	TotalSize      int    `json:"totalSize"`
+	Done           bool   `json:"done"`
+	NextRecordsUrl string `json:"nextRecordsUrl"`
+	Records        []T    `json:"records"`
+}
+
+// ToolingQuery runs a Tooling API query and returns all matching records
+// decoded into T, which can be any struct with JSON tags matching the
+// selected fields.
+//
+//	type ApexTrigger struct {
+//		Id   string `json:"Id"`
+//		Name string `json:"Name"`
+//	}
+//	triggers, err := sfapi.ToolingQuery[ApexTrigger](c, ctx, "SELECT Id, Name FROM ApexTrigger")
+func ToolingQuery[T any](c *Connection, ctx context.Context, query string) ([]T, error) {
+	return queryAll[T](c, ctx, soql.Tooling.Path(c.ApiVersion, query))
+}
+
+// ToolingQueryPages runs a Tooling API query and calls fn with every page of
+// results as it's received, following nextRecordsUrl until the last page.
+// Returning an error from fn stops the query.
+func ToolingQueryPages[T any](
+	c *Connection,
+	ctx context.Context,
+	query string,
+	fn func(QueryPage[T]) error,
+) error {
+	return queryPages(c, ctx, soql.Tooling.Path(c.ApiVersion, query), fn)
+}
+
+func queryPages[T any](c *Connection, ctx context.Context, path string, fn func(QueryPage[T]) error) error {
+	pageUrl := c.BaseUrl + path
+
+	for {
+		req, err := http.NewRequest(http.MethodGet, pageUrl, nil)
+		if err != nil {
+			return fmt.Errorf("http.NewRequest: %w", err)
+		}
+
+		respBody, err := c.DoRequest(ctx, req)
+		if err != nil {
+			return fmt.Errorf("c.makeRequest: %w", err)
+		}
+
+		var page QueryPage[T]
+		err = json.Unmarshal(respBody, &page)
+		if err != nil {
+			return fmt.Errorf("json.Unmarshal: %w", err)
+		}
+
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		if page.Done || page.NextRecordsUrl == "" {
+			return nil
+		}
+		pageUrl = c.BaseUrl + page.NextRecordsUrl
 	}
-	err = json.Unmarshal(respBody, &parsedResponse)
+}
+
+func queryAll[T any](c *Connection, ctx context.Context, path string) ([]T, error) {
+	var records []T
+	err := queryPages(c, ctx, path, func(page QueryPage[T]) error {
+		if records == nil {
+			records = make([]T, 0, page.TotalSize)
+		}
+		records = append(records, page.Records...)
+		return nil
+	})
 	if err != nil {
-		return []T{}, fmt.Errorf("json.Unmarshal: %w", err)
+		return []T{}, err
+	}
+
+	if records == nil {
+		records = []T{}
+	}
+
+	return records, nil
+}
+
+// queryToolingApiIn runs the query that build returns for values split into
+// as many chunks as needed to keep every request under maxQueryLength.
+// The chunks run concurrently and their records are merged in the order
+// of values.
+func queryToolingApiIn[T any](
+	c *Connection,
+	ctx context.Context,
+	values []string,
+	build func(chunk []string) *soql.Query,
+) ([]T, error) {
+	// The query around a single empty value is the overhead of every chunk.
+	// Chunks of names in several namespaces repeat a part of it, which the
+	// margin of maxQueryLength absorbs.
+	overhead := len(build([]string{""}).Path(c.ApiVersion)) - len(url.QueryEscape(soql.Quote("")))
+	chunks := chunkValues(values, maxQueryLength-overhead)
+	results := make([][]T, len(chunks))
+
+	g, gCtx := errgroup.WithContext(ctx)
+	g.SetLimit(maxConcurrentQueries)
+	for i, chunk := range chunks {
+		g.Go(func() error {
+			records, err := queryAll[T](c, gCtx, build(chunk).Path(c.ApiVersion))
+			if err != nil {
+				return err
+			}
+			results[i] = records
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return []T{}, err
+	}
+
+	records := make([]T, 0)
+	for _, r := range results {
+		records = append(records, r...)
+	}
+
+	return records, nil
+}
+
+// chunkValues splits values into chunks whose URL encoded SOQL literals add up
+// to at most maxLength, unless a single value is longer than that.
+func chunkValues(values []string, maxLength int) [][]string {
+	var (
+		chunks [][]string
+		chunk  []string
+		length int
+	)
+	for _, v := range values {
+		literalLength := len(url.QueryEscape(soql.Quote(v) + ","))
+		if len(chunk) > 0 && length+literalLength > maxLength {
+			chunks = append(chunks, chunk)
+			chunk, length = nil, 0
+		}
+		chunk = append(chunk, v)
+		length += literalLength
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
 	}
 
-	return parsedResponse.Records, nil
+	return chunks
 }
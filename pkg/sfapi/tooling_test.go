@@ -0,0 +1,125 @@
+package sfapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/achere/g-force/pkg/sfapi/soql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		values    []string
+		maxLength int
+		want      [][]string
+	}{
+		{
+			name:      "Empty",
+			maxLength: 100,
+		},
+		{
+			name:      "Single chunk",
+			values:    []string{"A", "B"},
+			maxLength: 100,
+			want:      [][]string{{"A", "B"}},
+		},
+		{
+			name:      "Split",
+			values:    []string{"A", "B", "C"},
+			maxLength: 2 * len("%27A%27%2C"),
+			want:      [][]string{{"A", "B"}, {"C"}},
+		},
+		{
+			name:      "Value longer than limit",
+			values:    []string{"Long_Name", "A"},
+			maxLength: 5,
+			want:      [][]string{{"Long_Name"}, {"A"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, chunkValues(tt.values, tt.maxLength))
+		})
+	}
+}
+
+func TestRequestApexClassesChunked(t *testing.T) {
+	const classCount = 2000
+
+	var queries atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/oauth2/token" {
+			json.NewEncoder(w).Encode(TokenResponse{AccessToken: "token"})
+			return
+		}
+
+		q := r.URL.Query().Get("q")
+		if strings.HasSuffix(q, "FROM Organization") {
+			json.NewEncoder(w).Encode(QueryPage[map[string]any]{TotalSize: 1, Done: true, Records: []map[string]any{{"NamespacePrefix": nil}}})
+			return
+		}
+
+		queries.Add(1)
+		assert.LessOrEqual(t, len(r.URL.RequestURI()), maxQueryLength)
+
+		list := q[strings.Index(q, "IN (")+4 : strings.Index(q, "')")+1]
+		var records []ApexClass
+		for _, name := range strings.Split(list, ",") {
+			records = append(records, ApexClass{Name: strings.Trim(name, "'")})
+		}
+		json.NewEncoder(w).Encode(QueryPage[ApexClass]{TotalSize: len(records), Done: true, Records: records})
+	}))
+	defer mockServer.Close()
+
+	c := &Connection{
+		BaseUrl:    mockServer.URL,
+		ApiVersion: "60.0",
+		HttpClient: mockServer.Client(),
+	}
+
+	names := make([]string, classCount)
+	for i := range names {
+		names[i] = fmt.Sprintf("Very_Long_Apex_Class_Name_%d", i)
+	}
+
+	classes, err := c.RequestApexClasses(context.Background(), names)
+	require.NoError(t, err)
+	require.Len(t, classes, classCount)
+	for i, class := range classes {
+		assert.Equal(t, names[i], class.Name)
+	}
+	assert.Greater(t, queries.Load(), int32(1))
+}
+
+func TestApexNameIn(t *testing.T) {
+	cond := apexNameIn("ApexClassOrTrigger.", []string{"Class1", "acme__Class2", "Class3", "acme__Class4"}, "")
+	query := soql.Select("Id").From("ApexCodeCoverage").Where(cond).String()
+
+	assert.Equal(
+		t,
+		"SELECT Id FROM ApexCodeCoverage WHERE "+
+			"((ApexClassOrTrigger.Name IN ('Class1','Class3')) AND (ApexClassOrTrigger.NamespacePrefix = null)) OR "+
+			"((ApexClassOrTrigger.Name IN ('Class2','Class4')) AND (ApexClassOrTrigger.NamespacePrefix = 'acme'))",
+		query,
+	)
+
+	query = soql.Select("Id").From("ApexClass").Where(apexNameIn("", []string{"Class1"}, "own")).String()
+	assert.Equal(
+		t,
+		"SELECT Id FROM ApexClass WHERE (Name IN ('Class1')) AND (NamespacePrefix IN (null,'own'))",
+		query,
+	)
+
+	ns, name := SplitQualifiedName("acme__Class2")
+	assert.Equal(t, "acme__Class2", QualifiedName(ns, name))
+}